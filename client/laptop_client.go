@@ -1,11 +1,13 @@
 package client
 
 import (
-	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"time"
@@ -14,6 +16,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 type LaptopClient struct {
@@ -43,36 +46,169 @@ func (laptopClient LaptopClient) CreateLaptop(laptop *pb.Laptop) {
 		return
 	}
 
-	log.Printf("Laptop is created with id:%s", res.Id)
+	log.Printf("Laptop is created with id:%s and version:%d", res.Id, res.Version)
 }
 
-func (laptopClient *LaptopClient) SerachLaptop(filter *pb.Filter) {
-	log.Println("search filter:", filter)
+func (laptopClient *LaptopClient) BatchCreateLaptops(laptops []*pb.Laptop, allOrNothing bool) (*pb.BatchCreateLaptopsResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	stream, err := laptopClient.service.BatchCreateLaptops(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't create laptops %v", err)
+	}
+
+	req := &pb.BatchCreateLaptopsRequest{
+		Value: &pb.BatchCreateLaptopsRequest_Options{
+			Options: &pb.BatchCreateOptions{AllOrNothing: allOrNothing},
+		},
+	}
+	err = stream.Send(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't send options %v %v", err, stream.RecvMsg(nil))
+	}
+
+	for _, laptop := range laptops {
+		req := &pb.BatchCreateLaptopsRequest{
+			Value: &pb.BatchCreateLaptopsRequest_Laptop{Laptop: laptop},
+		}
+		err = stream.Send(req)
+		if err != nil {
+			return nil, fmt.Errorf("can't send laptop %v %v", err, stream.RecvMsg(nil))
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, fmt.Errorf("can't receive response %v", err)
+	}
+
+	log.Printf("%d laptops are created out of %d", res.GetCreatedCount(), len(laptops))
+	return res, nil
+}
+
+func (laptopClient *LaptopClient) GetLaptop(laptopID string) (*pb.Laptop, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.GetLaptopRequest{Id: laptopID}
+
+	res, err := laptopClient.service.GetLaptop(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("can't get laptop %v", err)
+	}
+
+	return res.GetLaptop(), nil
+}
+
+func (laptopClient *LaptopClient) UpdateLaptop(laptop *pb.Laptop) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.UpdateLaptopRequest{Laptop: laptop}
+
+	_, err := laptopClient.service.UpdateLaptop(ctx, req)
+	if err != nil {
+		return fmt.Errorf("can't update laptop %v", err)
+	}
+
+	log.Printf("Laptop is updated with id:%s", laptop.GetId())
+	return nil
+}
+
+func (laptopClient *LaptopClient) PatchLaptop(laptop *pb.Laptop, paths ...string) (*pb.Laptop, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.PatchLaptopRequest{
+		Laptop:     laptop,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+	}
+
+	res, err := laptopClient.service.PatchLaptop(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("can't patch laptop %v", err)
+	}
+
+	log.Printf("Laptop is patched with id:%s", laptop.GetId())
+	return res.GetLaptop(), nil
+}
+
+func (laptopClient *LaptopClient) DeleteLaptop(laptopID string, version uint64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.DeleteLaptopRequest{Id: laptopID, Version: version}
+
+	_, err := laptopClient.service.DeleteLaptop(ctx, req)
+	if err != nil {
+		return fmt.Errorf("can't delete laptop %v", err)
+	}
+
+	log.Printf("Laptop is deleted with id:%s", laptopID)
+	return nil
+}
 
+func (laptopClient *LaptopClient) FacetLaptops(filter *pb.Filter) (*pb.FacetLaptopsResponse, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	req := &pb.SearchLaptopRequest{Filter: filter}
-	stream, err := laptopClient.service.SearchLaptop(ctx, req)
+	req := &pb.FacetLaptopsRequest{Filter: filter}
+
+	res, err := laptopClient.service.FacetLaptops(ctx, req)
 	if err != nil {
-		log.Fatal("can't search", err)
+		return nil, fmt.Errorf("can't facet laptops %v", err)
 	}
 
+	return res, nil
+}
+
+func (laptopClient *LaptopClient) SerachLaptop(filter *pb.Filter, sortBy ...*pb.SortKey) {
+	log.Println("search filter:", filter, "sort keys:", sortBy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.SearchLaptopRequest{Filter: filter, SortBy: sortBy}
 	for {
-		res, err := stream.Recv()
-		if err == io.EOF {
-			return
+		stream, err := laptopClient.service.SearchLaptop(ctx, req)
+		if err != nil {
+			log.Fatal("can't search", err)
 		}
 
-		if err != nil {
-			log.Fatal("can't receive response: ", err)
+		req.PageToken = ""
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				log.Fatal("can't receive response: ", err)
+			}
+
+			laptop := res.GetLaptop()
+			log.Print("-found:", laptop)
+			if res.GetNextPageToken() != "" {
+				req.PageToken = res.GetNextPageToken()
+			}
 		}
 
-		laptop := res.GetLaptop()
-		log.Print("-found:", laptop)
+		if req.PageToken == "" {
+			return
+		}
+		log.Print("fetching the next page")
 	}
 }
 
+// number of attempts in a row which may fail to upload more of an image
+const maxUploadAttempts = 5
+
+// size of the chunks of the uploaded images
+const uploadChunkSize = 64 << 10
+
+// UploadImage uploads the image in an upload session, an attempt timing out or failing is resumed
+// from the size the server already received
 func (laptopClient *LaptopClient) UploadImage(laptopID string, path string) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -81,59 +217,161 @@ func (laptopClient *LaptopClient) UploadImage(laptopID string, path string) {
 
 	defer file.Close()
 
+	// the server checks the digest of the data it receives
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		log.Fatal("can't read image file", err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	stream, err := laptopClient.service.UploadImage(ctx)
+	req := &pb.StartUploadRequest{
+		Info: &pb.ImageInfo{
+			LaptopId:   laptopID,
+			ImageTypes: filepath.Ext(path), //get the extension of the file
+			Sha256:     hex.EncodeToString(hash.Sum(nil)),
+		},
+	}
+	upload, err := laptopClient.service.StartUpload(ctx, req)
 	if err != nil {
-		log.Fatal("can't upload image:", err)
+		log.Fatal("can't start upload:", err)
 	}
+	uploadID := upload.GetUploadId()
 
-	req := &pb.UploadImageRequest{
-		Data: &pb.UploadImageRequest_Into{
-			Into: &pb.ImageInfo{
-				LaptopId:   laptopID,
-				ImageTypes: filepath.Ext(path), //get the extension of the file
-			},
-		},
+	var uploaded int64
+	for failures := 0; uploaded < size; {
+		offset, err := laptopClient.uploadChunks(uploadID, file)
+		if err != nil {
+			log.Print("upload interrupted:", err)
+		}
+		if offset > uploaded {
+			// only the attempts which upload nothing more in a row are counted
+			uploaded = offset
+			failures = 0
+			continue
+		}
+
+		failures++
+		if failures == maxUploadAttempts {
+			log.Fatalf("can't upload image, %d attempts in a row made no progress", failures)
+		}
 	}
 
-	err = stream.Send(req)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := laptopClient.service.FinalizeUpload(ctx, &pb.FinalizeUploadRequest{UploadId: uploadID, Size: uint64(size)})
 	if err != nil {
-		log.Fatal("can't send image info:", err)
+		log.Fatal("can't finalize upload:", err)
 	}
 
-	reader := bufio.NewReader(file)
-	buffer := make([]byte, 1024)
+	log.Printf("Image uploaded with id: %s, size: %d and deduplicated: %t", res.GetId(), res.GetSize(), res.GetDeduplicated())
+}
+
+// uploadChunks sends the data of the file following the size the server already received, and returns
+// the size received once it is done, or the size it had received before if the chunks fail
+func (laptopClient *LaptopClient) uploadChunks(uploadID string, file *os.File) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
+	upload, err := laptopClient.service.QueryUpload(ctx, &pb.QueryUploadRequest{UploadId: uploadID})
+	if err != nil {
+		return 0, fmt.Errorf("can't query upload %v", err)
+	}
+	committed := int64(upload.GetOffset())
+	offset := committed
+
+	stream, err := laptopClient.service.UploadChunks(ctx)
+	if err != nil {
+		return committed, fmt.Errorf("can't upload chunks %v", err)
+	}
+
+	reader := io.NewSectionReader(file, offset, math.MaxInt64)
+	buffer := make([]byte, uploadChunkSize)
 	for {
 		n, err := reader.Read(buffer)
+		if n > 0 {
+			req := &pb.UploadChunkRequest{
+				UploadId:  uploadID,
+				Offset:    uint64(offset),
+				ChunkData: buffer[:n],
+			}
+			// the error ending the stream is returned by CloseAndRecv
+			if stream.Send(req) != nil {
+				break
+			}
+			offset += int64(n)
+		}
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Fatal("can't read chunk to buffer")
+			return committed, fmt.Errorf("can't read chunk to buffer %v", err)
 		}
+	}
 
-		req := &pb.UploadImageRequest{
-			Data: &pb.UploadImageRequest_ChunkData{
-				ChunkData: buffer[:n],
-			},
-		}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return committed, fmt.Errorf("can't upload chunks %v", err)
+	}
+	return int64(res.GetOffset()), nil
+}
 
-		err = stream.Send(req)
-		if err != nil {
-			log.Fatal("Can't send chunk to server", err)
-		}
+// DownloadImage writes the image, or its variant resized to fit in variant pixels if it is not 0,
+// to the path and returns its info
+func (laptopClient *LaptopClient) DownloadImage(imageID string, variant uint32, path string) (*pb.ImageInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	stream, err := laptopClient.service.DownloadImage(ctx, &pb.DownloadImageRequest{ImageId: imageID, Variant: variant})
+	if err != nil {
+		return nil, fmt.Errorf("can't download image %v", err)
 	}
 
-	res, err := stream.CloseAndRecv()
+	res, err := stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("can't receive image info %v", err)
+	}
+	info := res.GetInfo()
+	if info == nil {
+		return nil, fmt.Errorf("image info is not received first")
+	}
+
+	file, err := os.Create(path)
 	if err != nil {
-		err2 := stream.RecvMsg(nil)
-		log.Fatal("Can't receive and close server connection:", err, err2)
+		return nil, fmt.Errorf("can't create image file %v", err)
 	}
+	defer file.Close()
+
+	// a partial image is not left behind
+	err = receiveImageData(stream, file)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	log.Printf("Image %s downloaded to %s", imageID, path)
+	return info, nil
+}
+
+func receiveImageData(stream pb.LaptopService_DownloadImageClient, writer io.Writer) error {
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("can't receive chunk data %v", err)
+		}
 
-	log.Printf("Image uploaded with id: %s and size: %d", res.GetId(), res.GetSize())
+		_, err = writer.Write(res.GetChunkData())
+		if err != nil {
+			return fmt.Errorf("can't write chunk data %v", err)
+		}
+	}
 }
 
 func (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float64) error {
@@ -159,6 +397,10 @@ func (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float6
 				waitReponse <- fmt.Errorf("can't receive stream response %v", err)
 				return
 			}
+			if res.GetErrorCode() != int32(codes.OK) {
+				log.Printf("can't rate laptop %s: %s", res.GetLaptopId(), res.GetErrorMessage())
+				continue
+			}
 			log.Println("Received response:", res)
 		}
 	}()
@@ -186,3 +428,107 @@ func (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float6
 
 	return err
 }
+
+func (laptopClient *LaptopClient) GetLaptopRatingStats(laptopIDs ...string) ([]*pb.LaptopRatingStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.GetLaptopRatingStatsRequest{LaptopIds: laptopIDs}
+
+	res, err := laptopClient.service.GetLaptopRatingStats(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("can't get rating stats %v", err)
+	}
+
+	return res.GetStats(), nil
+}
+
+func (laptopClient *LaptopClient) ListTopRatedLaptops(filter *pb.Filter, limit uint32) ([]*pb.ListTopRatedLaptopsResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.ListTopRatedLaptopsRequest{Filter: filter, Limit: limit}
+	stream, err := laptopClient.service.ListTopRatedLaptops(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("can't list top rated laptops %v", err)
+	}
+
+	var laptops []*pb.ListTopRatedLaptopsResponse
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return laptops, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't receive response %v", err)
+		}
+		laptops = append(laptops, res)
+	}
+}
+
+func (laptopClient *LaptopClient) SubmitReview(laptopID string, title string, body string, score float64) (*pb.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.SubmitReviewRequest{LaptopId: laptopID, Title: title, Body: body, Score: score}
+
+	res, err := laptopClient.service.SubmitReview(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("can't submit review %v", err)
+	}
+
+	log.Printf("Review is submitted with id:%s, replaced:%v", res.GetReview().GetId(), res.GetReplaced())
+	return res.GetReview(), nil
+}
+
+// ListLaptopReviews returns every visible review of the laptop, following the pages
+func (laptopClient *LaptopClient) ListLaptopReviews(laptopID string) ([]*pb.Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var reviews []*pb.Review
+	req := &pb.ListLaptopReviewsRequest{LaptopId: laptopID}
+	for {
+		res, err := laptopClient.service.ListLaptopReviews(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("can't list reviews %v", err)
+		}
+
+		reviews = append(reviews, res.GetReviews()...)
+		req.PageToken = res.GetNextPageToken()
+		if req.PageToken == "" {
+			return reviews, nil
+		}
+	}
+}
+
+// WatchLaptops calls handle with the changes of the laptops matching the filter until the context is done,
+// the watch is resumed from the last event if the server drops it for being too slow
+func (laptopClient *LaptopClient) WatchLaptops(ctx context.Context, filter *pb.Filter, handle func(event *pb.WatchLaptopsResponse)) error {
+	req := &pb.WatchLaptopsRequest{Filter: filter}
+	for {
+		stream, err := laptopClient.service.WatchLaptops(ctx, req)
+		if err != nil {
+			return fmt.Errorf("can't watch laptops %v", err)
+		}
+
+		for {
+			res, err := stream.Recv()
+			if status.Code(err) == codes.ResourceExhausted {
+				log.Print("watch is dropped, resuming it")
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("can't receive event %v", err)
+			}
+
+			if res.GetResumeToken() != "" {
+				req.ResumeToken = res.GetResumeToken()
+			}
+			// the progress events only move the token forward
+			if res.GetType() != pb.WatchLaptopsResponse_PROGRESS {
+				handle(res)
+			}
+		}
+	}
+}
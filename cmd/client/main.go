@@ -35,7 +35,7 @@ func testSeachLaptop(laptopClient client.LaptopClient) {
 		MinRam:      &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE},
 	}
 
-	laptopClient.SerachLaptop(filter)
+	laptopClient.SerachLaptop(filter, &pb.SortKey{Field: pb.SortKey_PRICE_USD})
 }
 
 func testRateLaptop(laptopClient client.LaptopClient) {
@@ -77,9 +77,22 @@ const (
 func authMethods() map[string]bool {
 	const laptopServicePath = "/mypackage.LaptopService/"
 	return map[string]bool{
-		laptopServicePath + "CreateLaptop": true,
-		laptopServicePath + "UploadImage":  true,
-		laptopServicePath + "RateLaptop":   true,
+		laptopServicePath + "CreateLaptop":       true,
+		laptopServicePath + "BatchCreateLaptops": true,
+		laptopServicePath + "GetLaptop":          true,
+		laptopServicePath + "UpdateLaptop":       true,
+		laptopServicePath + "PatchLaptop":        true,
+		laptopServicePath + "DeleteLaptop":       true,
+		laptopServicePath + "UploadImage":        true,
+		laptopServicePath + "StartUpload":        true,
+		laptopServicePath + "UploadChunks":       true,
+		laptopServicePath + "QueryUpload":        true,
+		laptopServicePath + "FinalizeUpload":     true,
+		laptopServicePath + "DownloadImage":      true,
+		laptopServicePath + "DeleteImage":        true,
+		laptopServicePath + "RateLaptop":         true,
+		laptopServicePath + "SubmitReview":       true,
+		laptopServicePath + "ModerateReview":     true,
 	}
 }
 
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/moataz-hamed/pb/pb"
@@ -13,8 +20,11 @@ import (
 )
 
 const (
-	secretKey     = "secret"
-	tokenDuration = 15 * time.Minute
+	secretKey       = "secret"
+	tokenDuration   = 15 * time.Minute
+	snapshotEvery   = 1000 // laptop log records before they are compacted into a snapshot
+	shutdownTimeout = 10 * time.Second
+	minUploadTTL    = time.Minute // also bounds how often the expired uploads are looked for
 )
 
 func seedUser(userStore service.UserStore) error {
@@ -31,34 +41,228 @@ func createUser(userStore service.UserStore, username, password, role string) er
 		return err
 	}
 
-	return userStore.Save(user)
+	err = userStore.Save(user)
+	if errors.Is(err, service.ErrAlreadyExists) {
+		// the user was persisted by a previous run
+		return nil
+	}
+	return err
 }
 
 func accessibleRoles() map[string][]string {
 	const laptopServicePath = "/mypackage.LaptopService/"
 	return map[string][]string{
-		laptopServicePath + "CreateLaptop": {"admin"},
-		laptopServicePath + "UploadImage":  {"admin"},
-		laptopServicePath + "RateLaptop":   {"admin", "user"},
+		laptopServicePath + "CreateLaptop":       {"admin"},
+		laptopServicePath + "BatchCreateLaptops": {"admin"},
+		laptopServicePath + "GetLaptop":          {"admin"},
+		laptopServicePath + "UpdateLaptop":       {"admin"},
+		laptopServicePath + "PatchLaptop":        {"admin"},
+		laptopServicePath + "DeleteLaptop":       {"admin"},
+		laptopServicePath + "UploadImage":        {"admin"},
+		laptopServicePath + "StartUpload":        {"admin"},
+		laptopServicePath + "UploadChunks":       {"admin"},
+		laptopServicePath + "QueryUpload":        {"admin"},
+		laptopServicePath + "FinalizeUpload":     {"admin"},
+		laptopServicePath + "DownloadImage":      {"admin", "user"},
+		laptopServicePath + "DeleteImage":        {"admin"},
+		laptopServicePath + "RateLaptop":         {"admin", "user"},
+		laptopServicePath + "SubmitReview":       {"admin", "user"},
+		laptopServicePath + "ModerateReview":     {"admin"},
+	}
+}
+
+type stores struct {
+	user      service.UserStore
+	laptop    service.LaptopStore
+	rating    service.RatingStore
+	review    service.ReviewStore
+	imageInfo service.ImageInfoStore
+	closer    io.Closer // closes the files the stores are persisted in, nil if they are only in memory
+}
+
+// Close flushes and closes the files of the stores, they must not be used afterwards
+func (stores *stores) Close() error {
+	if stores.closer == nil {
+		return nil
+	}
+	return stores.closer.Close()
+}
+
+// newStores keeps everything in memory unless dbPath is set to persist every store in one database file,
+// or dataDir is set to persist only the laptops
+func newStores(dataDir string, dbPath string) (*stores, error) {
+	if dbPath != "" {
+		if dataDir != "" {
+			return nil, fmt.Errorf("-data-dir and -db can't be used together")
+		}
+
+		db, err := service.OpenBoltStore(dbPath)
+		if err != nil {
+			return nil, err
+		}
+
+		laptopStore, err := db.LaptopStore()
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		return &stores{
+			user:      db.UserStore(),
+			laptop:    laptopStore,
+			rating:    db.RatingStore(),
+			review:    db.ReviewStore(),
+			imageInfo: db.ImageInfoStore(),
+			closer:    db,
+		}, nil
+	}
+
+	stores := &stores{
+		user:      service.NewInMemoryUserStore(),
+		laptop:    service.NewInMemoryLaptopStore(),
+		rating:    service.NewInMemoryRatingStore(),
+		review:    service.NewInMemoryReviewStore(),
+		imageInfo: service.NewInMemoryImageInfoStore(),
+	}
+	if dataDir != "" {
+		laptopStore, err := service.NewFileLaptopStore(dataDir, snapshotEvery)
+		if err != nil {
+			return nil, err
+		}
+		stores.laptop = laptopStore
+		stores.closer = laptopStore
+	}
+	return stores, nil
+}
+
+// parseVariantSizes parses the comma separated sizes of the image variants, there is none if the list is empty
+func parseVariantSizes(list string) ([]uint32, error) {
+	var sizes []uint32
+	for _, field := range strings.Split(list, ",") {
+		if field == "" {
+			continue
+		}
+
+		size, err := strconv.ParseUint(field, 10, 32)
+		if err != nil || size == 0 {
+			return nil, fmt.Errorf("invalid size %q", field)
+		}
+		sizes = append(sizes, uint32(size))
+	}
+	return sizes, nil
+}
+
+// removeExpiredUploads removes the abandoned upload sessions until stop is closed, checking several times
+// per TTL so they are not kept much longer than it
+func removeExpiredUploads(uploadStore service.UploadStore, ttl time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(ttl / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := uploadStore.RemoveExpired(time.Now())
+		if err != nil {
+			log.Print("Can not remove expired uploads:", err)
+		}
+		if removed > 0 {
+			log.Printf("removed %d expired uploads", removed)
+		}
 	}
 }
 
+// stopOnSignal stops the server once the process is interrupted or terminated, the streams still open after
+// the timeout are cut so the watchers don't keep it running
+func stopOnSignal(grpcServer *grpc.Server, timeout time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Print("stopping server")
+
+	timer := time.AfterFunc(timeout, grpcServer.Stop)
+	grpcServer.GracefulStop()
+	timer.Stop()
+}
+
 func main() {
 	port := flag.Int("port", 0, "the server port")
+	dataDir := flag.String("data-dir", "", "the directory to persist laptops in, they are only kept in memory if empty")
+	dbPath := flag.String("db", "", "the database file to persist users, laptops, ratings, reviews and image metadata in")
+	ratingMin := flag.Float64("rating-min", service.DefaultRatingScale.Min, "the lowest score users can give")
+	ratingMax := flag.Float64("rating-max", service.DefaultRatingScale.Max, "the highest score users can give")
+	ratingHalfSteps := flag.Bool("rating-half-steps", false, "allow scores by half steps instead of whole steps")
+	minVotes := flag.Uint("rating-min-votes", 5, "the number of votes a laptop needs before its own average outweighs the prior in the top rated laptops")
+	maxImageSize := flag.Int64("max-image-size", 1<<20, "the maximum size in bytes of the uploaded images")
+	imageTypes := flag.String("image-types", "image/jpeg,image/png,image/gif,image/webp", "the comma separated content types of the images which can be uploaded")
+	imageVariants := flag.String("image-variants", "128,512", "the comma separated sizes in pixels of the resized variants generated for the PNG and JPEG images")
+	variantWorkers := flag.Int("image-variant-workers", 2, "the number of images whose variants are generated at the same time")
+	maxImagePixels := flag.Int64("max-image-pixels", 50_000_000, "the maximum number of pixels of the images which are decoded to be resized")
+	pageTokenKey := flag.String("page-token-key", os.Getenv("PAGE_TOKEN_KEY"), "the key signing the search page tokens, defaults to $PAGE_TOKEN_KEY. A random key is used if both are empty, so the tokens don't outlive the server")
+	uploadTTL := flag.Duration("upload-ttl", 24*time.Hour, "the time after which an upload session with no new data is removed, at least a minute")
+	maxUploads := flag.Int("max-uploads", 1000, "the maximum number of upload sessions in progress, each keeping a file open")
 	flag.Parse()
 	log.Printf("start server on port %d", *port)
 
-	userStore := service.NewInMemoryUserStore()
+	ratingScale := service.RatingScale{Min: *ratingMin, Max: *ratingMax, HalfSteps: *ratingHalfSteps}
+	err := ratingScale.Validate()
+	if err != nil {
+		log.Fatal("Invalid rating scale:", err)
+	}
+
+	stores, err := newStores(*dataDir, *dbPath)
+	if err != nil {
+		log.Fatal("Can not open stores:", err)
+	}
 
-	err := seedUser(userStore)
+	err = seedUser(stores.user)
 	if err != nil {
-		log.Fatal("Error:%v", err)
+		log.Fatalf("Error:%v", err)
 	}
 
 	jwtManager := service.NewJWTManager(secretKey, tokenDuration)
-	authServer := service.NewAuthServer(userStore, jwtManager)
+	authServer := service.NewAuthServer(stores.user, jwtManager)
+
+	variantSizes, err := parseVariantSizes(*imageVariants)
+	if err != nil {
+		log.Fatal("Invalid image variants:", err)
+	}
+	imageStore := service.NewDiskImageStore("img", stores.imageInfo,
+		service.WithImageVariants(variantSizes, *variantWorkers),
+		service.WithMaxImagePixels(*maxImagePixels),
+	)
+	if *uploadTTL < minUploadTTL {
+		log.Fatal("Invalid upload TTL:", *uploadTTL)
+	}
+	if *maxUploads <= 0 {
+		log.Fatal("Invalid max uploads:", *maxUploads)
+	}
+	uploadStore, err := service.NewDiskUploadStore("img/uploads", *uploadTTL, service.WithMaxUploads(*maxUploads))
+	if err != nil {
+		log.Fatal("Can not open upload store:", err)
+	}
+	stopUploads := make(chan struct{})
+	go removeExpiredUploads(uploadStore, *uploadTTL, stopUploads)
 
-	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), service.NewDiskImageStore("img"), service.NewInMemoryRatingStore())
+	options := []service.LaptopServerOption{
+		service.WithRatingScale(ratingScale),
+		service.WithMinVotes(uint32(*minVotes)),
+		service.WithMaxImageSize(*maxImageSize),
+		service.WithUploadStore(uploadStore),
+		service.WithReviewStore(stores.review),
+		service.WithImageContentTypes(strings.Split(*imageTypes, ",")...),
+	}
+	// the page tokens are signed with their own key, never with the one signing the access tokens
+	if *pageTokenKey != "" {
+		options = append(options, service.WithPageTokenKey([]byte(*pageTokenKey)))
+	}
+	laptopServer, err := service.NewLaptopServer(stores.laptop, imageStore, stores.rating, options...)
+	if err != nil {
+		log.Fatal("Can not create laptop server:", err)
+	}
 
 	interceptor := service.NewAuthInterceptor(*jwtManager, accessibleRoles())
 
@@ -75,8 +279,18 @@ func main() {
 		log.Fatal("Can not start server:", err)
 	}
 
+	go stopOnSignal(grpcServer, shutdownTimeout)
+
 	err = grpcServer.Serve(listener)
 	if err != nil {
 		log.Fatal("Can not start server2:", err)
 	}
+
+	close(stopUploads)
+	laptopServer.Close()
+	imageStore.Close()
+	err = stores.Close()
+	if err != nil {
+		log.Fatal("Can not close stores:", err)
+	}
 }
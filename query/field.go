@@ -0,0 +1,206 @@
+package query
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+
+	"github.com/moataz-hamed/pb/pb"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type fieldKind int
+
+const (
+	numberField fieldKind = iota
+	stringField
+	boolField
+	enumField
+	memoryField
+)
+
+// bitsPerUnit converts the sizes of Memory fields and literals to bits
+var bitsPerUnit = map[string]float64{
+	"BIT": 1,
+	"B":   8,
+	"KB":  8 << 10,
+	"MB":  8 << 20,
+	"GB":  8 << 30,
+	"TB":  8 << 40,
+}
+
+var memoryName = (&pb.Memory{}).ProtoReflect().Descriptor().FullName()
+
+// field is a resolved path from the queried message to a field which can be compared
+type field struct {
+	name string
+	path []protoreflect.FieldDescriptor
+	kind fieldKind
+}
+
+func (f *field) ordered() bool {
+	return f.kind == numberField || f.kind == memoryField
+}
+
+func resolvePath(desc protoreflect.MessageDescriptor, t token) (*field, error) {
+	f := &field{name: t.text}
+	pos := t.pos
+
+	segments := strings.Split(t.text, ".")
+	for i, name := range segments {
+		if desc == nil {
+			return nil, errorAt(pos, "field %s has no sub field %s", segments[i-1], name)
+		}
+
+		fd := desc.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, errorAt(pos, "unknown field %s in %s", name, desc.Name())
+		}
+		f.path = append(f.path, fd)
+		pos += len([]rune(name)) + 1
+
+		desc = nil
+		if fd.Kind() == protoreflect.MessageKind && fd.Message().FullName() != memoryName {
+			desc = fd.Message()
+		}
+	}
+
+	last := f.path[len(f.path)-1]
+	switch {
+	case last.IsMap():
+		return nil, errorAt(t.pos, "map field %s can't be compared", t.text)
+	case last.Kind() == protoreflect.MessageKind && last.Message().FullName() == memoryName:
+		f.kind = memoryField
+	case last.Kind() == protoreflect.MessageKind || last.Kind() == protoreflect.GroupKind:
+		return nil, errorAt(t.pos, "field %s is a message, compare one of its fields", t.text)
+	case last.Kind() == protoreflect.StringKind:
+		f.kind = stringField
+	case last.Kind() == protoreflect.BoolKind:
+		f.kind = boolField
+	case last.Kind() == protoreflect.EnumKind:
+		f.kind = enumField
+	case last.Kind() == protoreflect.BytesKind:
+		return nil, errorAt(t.pos, "bytes field %s can't be compared", t.text)
+	default:
+		f.kind = numberField
+	}
+	return f, nil
+}
+
+// literal is a value of the query converted to the kind of the field it is compared with
+type literal struct {
+	number float64
+	text   string
+	flag   bool
+	enum   protoreflect.EnumNumber
+}
+
+func (p *parser) parseLiteral(f *field) (literal, error) {
+	t := p.take()
+
+	switch f.kind {
+	case numberField, memoryField:
+		if t.kind != tokenNumber {
+			return literal{}, errorAt(t.pos, "expected a number for field %s but found %s", f.name, describe(t))
+		}
+		number, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return literal{}, errorAt(t.pos, "invalid number %s", t.text)
+		}
+
+		if f.kind == numberField {
+			if t.value != "" {
+				return literal{}, errorAt(t.pos, "field %s is not a memory size and can't have unit %s", f.name, t.value)
+			}
+			return literal{number: number}, nil
+		}
+
+		bits, ok := bitsPerUnit[strings.ToUpper(t.value)]
+		if !ok {
+			return literal{}, errorAt(t.pos, "expected a memory size like 16GB for field %s but found %s", f.name, describe(t))
+		}
+		return literal{number: number * bits}, nil
+
+	case stringField:
+		if t.kind != tokenString {
+			return literal{}, errorAt(t.pos, "expected a quoted string for field %s but found %s", f.name, describe(t))
+		}
+		return literal{text: strings.ToLower(t.value)}, nil
+
+	case boolField:
+		if t.kind == tokenIdent && (strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false")) {
+			return literal{flag: strings.EqualFold(t.text, "true")}, nil
+		}
+		return literal{}, errorAt(t.pos, "expected true or false for field %s but found %s", f.name, describe(t))
+
+	default:
+		name := t.text
+		if t.kind == tokenString {
+			name = t.value
+		} else if t.kind != tokenIdent {
+			return literal{}, errorAt(t.pos, "expected a value name for field %s but found %s", f.name, describe(t))
+		}
+
+		values := f.path[len(f.path)-1].Enum().Values()
+		for i := 0; i < values.Len(); i++ {
+			if strings.EqualFold(string(values.Get(i).Name()), name) {
+				return literal{enum: values.Get(i).Number()}, nil
+			}
+		}
+		return literal{}, errorAt(t.pos, "unknown value %s for field %s", name, f.name)
+	}
+}
+
+// compare returns how the value of the field compares to the literal
+func (f *field) compare(value protoreflect.Value, l literal) int {
+	switch f.kind {
+	case stringField:
+		return strings.Compare(strings.ToLower(value.String()), l.text)
+	case boolField:
+		if value.Bool() == l.flag {
+			return 0
+		}
+		return 1
+	case enumField:
+		return cmp.Compare(value.Enum(), l.enum)
+	case memoryField:
+		return cmp.Compare(memoryBits(value.Message()), l.number)
+	default:
+		return cmp.Compare(toFloat(value), l.number)
+	}
+}
+
+func memoryBits(memory protoreflect.Message) float64 {
+	fields := memory.Descriptor().Fields()
+	value := memory.Get(fields.ByName("value")).Uint()
+	unit := pb.Memory_Unit(memory.Get(fields.ByName("unit")).Enum())
+	return float64(value) * bitsPerUnit[unitNames[unit]]
+}
+
+var unitNames = map[pb.Memory_Unit]string{
+	pb.Memory_BIT:      "BIT",
+	pb.Memory_BYTE:     "B",
+	pb.Memory_KILOBYTE: "KB",
+	pb.Memory_MEGABYTE: "MB",
+	pb.Memory_GIGABYTE: "GB",
+	pb.Memory_TERABYTE: "TB",
+}
+
+func toFloat(value protoreflect.Value) float64 {
+	switch v := value.Interface().(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,103 @@
+package query
+
+import "google.golang.org/protobuf/reflect/protoreflect"
+
+type node interface {
+	match(message protoreflect.Message) bool
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n *andNode) match(message protoreflect.Message) bool {
+	return n.left.match(message) && n.right.match(message)
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n *orNode) match(message protoreflect.Message) bool {
+	return n.left.match(message) || n.right.match(message)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) match(message protoreflect.Message) bool {
+	return !n.operand.match(message)
+}
+
+type comparisonNode struct {
+	field    *field
+	operator string
+	literals []literal
+}
+
+// match is true if any value reached by the path of the field satisfies the comparison
+func (n *comparisonNode) match(message protoreflect.Message) bool {
+	for _, value := range collect(message, n.field.path) {
+		if n.satisfies(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *comparisonNode) satisfies(value protoreflect.Value) bool {
+	if n.operator == "in" {
+		for _, l := range n.literals {
+			if n.field.compare(value, l) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := n.field.compare(value, n.literals[0])
+	switch n.operator {
+	case "=":
+		return result == 0
+	case "!=":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	default:
+		return false
+	}
+}
+
+// collect returns the values found at the end of the path, following every element of the repeated fields.
+// An unset scalar gives its default value while an unset message gives nothing
+func collect(message protoreflect.Message, path []protoreflect.FieldDescriptor) []protoreflect.Value {
+	fd := path[0]
+	last := len(path) == 1
+
+	var elements []protoreflect.Value
+	if fd.IsList() {
+		list := message.Get(fd).List()
+		for i := 0; i < list.Len(); i++ {
+			elements = append(elements, list.Get(i))
+		}
+	} else if fd.Kind() != protoreflect.MessageKind || message.Has(fd) {
+		elements = append(elements, message.Get(fd))
+	}
+
+	if last {
+		return elements
+	}
+
+	var values []protoreflect.Value
+	for _, element := range elements {
+		values = append(values, collect(element.Message(), path[1:])...)
+	}
+	return values
+}
@@ -0,0 +1,221 @@
+// Package query parses boolean expressions over the fields of protobuf messages, like
+//
+//	brand in ("Apple","Dell") and (ram >= 16GB or gpu.memory >= 8GB) and price_usd < 2000
+//
+// Fields are named by their proto names and nested fields are separated by dots. A path crossing a
+// repeated field matches when any of its elements does. Strings and enum names are compared case
+// insensitively, and Memory fields are compared with sizes such as 512MB, 16GB or 1TB.
+package query
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Query is a compiled expression which can be matched against messages of one type
+type Query struct {
+	desc protoreflect.MessageDescriptor
+	root node
+}
+
+// MaxLength is the maximum length in bytes of a query
+const MaxLength = 4096
+
+// MaxDepth is the maximum number of nested parentheses and negations in a query, the parser
+// recurses for each of them so their number must be bounded
+const MaxDepth = 100
+
+// Compile parses the input and resolves its fields in the message descriptor, it returns an *Error
+// pointing at the problem if the query is invalid
+func Compile(input string, desc protoreflect.MessageDescriptor) (*Query, error) {
+	if len(input) > MaxLength {
+		return nil, errorAt(MaxLength, "query is longer than %d bytes", MaxLength)
+	}
+
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, desc: desc}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, errorAt(next.pos, "unexpected %q", next.text)
+	}
+
+	return &Query{desc: desc, root: root}, nil
+}
+
+// Match tells whether the message satisfies the query, it never matches a message of another type
+func (query *Query) Match(message proto.Message) bool {
+	reflected := message.ProtoReflect()
+	if reflected.Descriptor().FullName() != query.desc.FullName() {
+		return false
+	}
+	return query.root.match(reflected)
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	desc   protoreflect.MessageDescriptor
+	depth  int // number of parentheses and negations the parser is in
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	t := p.take()
+	if t.kind != kind {
+		return errorAt(t.pos, "expected %q but found %s", text, describe(t))
+	}
+	return nil
+}
+
+// parseOr parses: and ("or" and)*
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("or") {
+		p.take()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary ("and" unary)*
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("and") {
+		p.take()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parses: "not" unary | "(" or ")" | comparison
+func (p *parser) parseUnary() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MaxDepth {
+		return nil, errorAt(p.peek().pos, "query is nested more than %d levels deep", MaxDepth)
+	}
+
+	if p.isKeyword("not") {
+		p.take()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+
+	if p.peek().kind == tokenLeftParen {
+		p.take()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(tokenRightParen, ")")
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses: path operator value | path "in" "(" value ("," value)* ")"
+func (p *parser) parseComparison() (node, error) {
+	t := p.take()
+	if t.kind != tokenIdent {
+		return nil, errorAt(t.pos, "expected a field but found %s", describe(t))
+	}
+
+	field, err := resolvePath(p.desc, t)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("in") {
+		p.take()
+		err := p.expect(tokenLeftParen, "(")
+		if err != nil {
+			return nil, err
+		}
+
+		comparison := &comparisonNode{field: field, operator: "in"}
+		for {
+			value, err := p.parseLiteral(field)
+			if err != nil {
+				return nil, err
+			}
+			comparison.literals = append(comparison.literals, value)
+
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.take()
+		}
+		return comparison, p.expect(tokenRightParen, ")")
+	}
+
+	operator := p.take()
+	if operator.kind != tokenOperator {
+		return nil, errorAt(operator.pos, "expected an operator but found %s", describe(operator))
+	}
+	switch operator.text {
+	case "=", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, errorAt(operator.pos, "unknown operator %s", operator.text)
+	}
+	if operator.text != "=" && operator.text != "!=" && !field.ordered() {
+		return nil, errorAt(operator.pos, "operator %s can't be used with field %s", operator.text, field.name)
+	}
+
+	value, err := p.parseLiteral(field)
+	if err != nil {
+		return nil, err
+	}
+	return &comparisonNode{field: field, operator: operator.text, literals: []literal{value}}, nil
+}
+
+func describe(t token) string {
+	if t.kind == tokenEOF {
+		return "the end of the query"
+	}
+	return `"` + t.text + `"`
+}
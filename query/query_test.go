@@ -0,0 +1,106 @@
+package query
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moataz-hamed/pb/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	laptop := &pb.Laptop{
+		Brand:    "DELL",
+		PriceUsd: 1500,
+		Ram:      &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE},
+		Gpu: []*pb.GPU{
+			{Name: "RTX", Memory: &pb.Memory{Value: 4, Unit: pb.Memory_GIGABYTE}},
+			{Name: "RX VEGA", Memory: &pb.Memory{Value: 8192, Unit: pb.Memory_MEGABYTE}},
+		},
+		Screen:   &pb.Screen{Panel: pb.Screen_OLED},
+		Keyboard: &pb.Keyboard{Backlit: true},
+	}
+
+	testCases := []struct {
+		query string
+		match bool
+	}{
+		{`brand in ("Apple","Dell") and (ram >= 16GB or gpu.memory >= 8GB) and price_usd < 2000`, true},
+		{`brand = "lenovo" or price_usd >= 1500.5`, false},
+		{`ram > 16GB or gpu.memory >= 8GB`, true},
+		{`gpu.memory > 8GB`, false},
+		{`not screen.panel = IPS and keyboard.backlit = true`, true},
+		{`screen.panel in ("ips") or cpu.number_cores > 0`, false},
+		{`gpu.name = "rtx" and not (release_year != 0)`, true},
+		{`price_usd = 100`, false},
+		{`price_usd = 1500 and price_usd >= 1500 and price_usd <= 1500`, true},
+	}
+
+	for _, tc := range testCases {
+		query, err := Compile(tc.query, laptop.ProtoReflect().Descriptor())
+		require.NoError(t, err, tc.query)
+		require.Equal(t, tc.match, query.Match(laptop), tc.query)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		query string
+		pos   int
+	}{
+		{`price_usd < `, 12},
+		{`brand = "Dell`, 8},
+		{`price = 10`, 0},
+		{`cpu.speed > 2`, 4},
+		{`ram >= 16`, 7},
+		{`brand < "a"`, 6},
+		{`(price_usd < 10`, 15},
+		{`price_usd < 10 brand = "a"`, 15},
+		{`screen.panel = LCD`, 15},
+		{`cpu > 1`, 0},
+		{`price_usd == 100`, 10},
+		{`brand == "Dell"`, 6},
+		{`ram >== 16GB`, 4},
+	}
+
+	desc := (&pb.Laptop{}).ProtoReflect().Descriptor()
+	for _, tc := range testCases {
+		_, err := Compile(tc.query, desc)
+		require.Error(t, err, tc.query)
+
+		queryErr, ok := err.(*Error)
+		require.True(t, ok, tc.query)
+		require.Equal(t, tc.pos, queryErr.Pos, "%s: %v", tc.query, err)
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	t.Parallel()
+
+	desc := (&pb.Laptop{}).ProtoReflect().Descriptor()
+
+	nested := strings.Repeat("(", MaxDepth-1) + "price_usd < 10" + strings.Repeat(")", MaxDepth-1)
+	_, err := Compile(nested, desc)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		query string
+		pos   int
+	}{
+		{strings.Repeat("(", MaxDepth) + "price_usd < 10" + strings.Repeat(")", MaxDepth), MaxDepth},
+		{strings.Repeat("not ", MaxDepth) + "price_usd < 10", 4 * MaxDepth},
+		{strings.Repeat("(", 3_000_000), MaxLength},
+		{"price_usd < 10" + strings.Repeat(" ", MaxLength), MaxLength},
+	}
+
+	for _, tc := range testCases {
+		_, err := Compile(tc.query, desc)
+		queryErr, ok := err.(*Error)
+		require.True(t, ok, "%v", err)
+		require.Equal(t, tc.pos, queryErr.Pos, "%v", err)
+	}
+}
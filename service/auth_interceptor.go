@@ -26,7 +26,7 @@ func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		log.Println("Unary Interceptor", info.FullMethod)
 
-		err := interceptor.authorize(ctx, info.FullMethod)
+		ctx, err := interceptor.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
@@ -39,44 +39,63 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		log.Println("Stream Interceptor", info.FullMethod)
 
-		err := interceptor.authorize(ss.Context(), info.FullMethod)
+		ctx, err := interceptor.authorize(ss.Context(), info.FullMethod)
 		if err != nil {
 			return err
 		}
-		return handler(srv, ss)
+		return handler(srv, &authServerStream{ServerStream: ss, ctx: ctx})
 	}
 }
 
-func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) error {
+// authorize returns the context holding the claims of the user once the access token is verified
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (context.Context, error) {
 	accesssibleRoles, ok := interceptor.accessibleRoles[method]
 	if !ok {
 		//means the rpc is publicly accessible
-		return nil
+		return ctx, nil
 	}
 
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return status.Errorf(codes.Unauthenticated, "metadata is not provided")
+		return nil, status.Errorf(codes.Unauthenticated, "metadata is not provided")
 	}
 	log.Println("-----------_>THis is md", md)
 
 	values := md["authorization"]
 	if len(values) == 0 {
-		return status.Errorf(codes.Unauthenticated, "authorization token not provided")
+		return nil, status.Errorf(codes.Unauthenticated, "authorization token not provided")
 	}
 
 	log.Println("values here----------_>", values)
 	accessToken := values[0]
 	claims, err := interceptor.jwtManager.Verify(accessToken)
 	if err != nil {
-		return status.Errorf(codes.Unauthenticated, "INVALID TOKEN:%v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "INVALID TOKEN:%v", err)
 	}
 
 	for _, role := range accesssibleRoles {
 		if role == claims.Role {
-			return nil
+			return context.WithValue(ctx, claimsKey{}, claims), nil
 		}
 	}
 
-	return status.Errorf(codes.PermissionDenied, "No Permission to access this RPC")
+	return nil, status.Errorf(codes.PermissionDenied, "No Permission to access this RPC")
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the user verified by the AuthInterceptor, or false for a public RPC
+func ClaimsFromContext(ctx context.Context) (*UserClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*UserClaims)
+	return claims, ok
+}
+
+// authServerStream hands the context holding the claims to the stream handlers
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *authServerStream) Context() context.Context {
+	return stream.ctx
 }
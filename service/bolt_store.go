@@ -0,0 +1,564 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/moataz-hamed/pb/pb"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	laptopBucket    = []byte("laptops")
+	userBucket      = []byte("users")
+	ratingBucket    = []byte("ratings")
+	scoreBucket     = []byte("scores")
+	imageInfoBucket = []byte("images")
+	imageBlobBucket = []byte("image_blobs")
+	reviewBucket    = []byte("reviews")
+	reviewKeyBucket = []byte("review_keys")
+	metaBucket      = []byte("meta")
+
+	laptopRevisionKey = []byte("laptop_revision")
+)
+
+// BoltStore is an embedded database file holding the users, laptops, ratings, reviews and image metadata,
+// the stores it hands out share its transactions so a change spanning several of them is atomic
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open database:%w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{laptopBucket, userBucket, ratingBucket, scoreBucket, imageInfoBucket, imageBlobBucket, reviewBucket, reviewKeyBucket, metaBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return fmt.Errorf("cannot create bucket %s:%w", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (store *BoltStore) Close() error {
+	return store.db.Close()
+}
+
+// BoltLaptopStore keeps the laptops in memory for searching, every change is written to the database
+// before it is applied
+type BoltLaptopStore struct {
+	*InMemoryLaptopStore
+	db *bolt.DB
+}
+
+// LaptopStore loads the laptops saved in the database
+func (store *BoltStore) LaptopStore() (*BoltLaptopStore, error) {
+	laptopStore := &BoltLaptopStore{
+		InMemoryLaptopStore: NewInMemoryLaptopStore(),
+		db:                  store.db,
+	}
+
+	record := &pb.LaptopLogRecord{}
+	err := store.db.View(func(tx *bolt.Tx) error {
+		revision := tx.Bucket(metaBucket).Get(laptopRevisionKey)
+		if revision != nil {
+			record.Revision = binary.BigEndian.Uint64(revision)
+		}
+
+		return tx.Bucket(laptopBucket).ForEach(func(key, value []byte) error {
+			laptop := &pb.Laptop{}
+			err := proto.Unmarshal(value, laptop)
+			if err != nil {
+				return fmt.Errorf("cannot unmarshal laptop %s:%w", key, err)
+			}
+			record.Saved = append(record.Saved, laptop)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	laptopStore.apply(record)
+	laptopStore.journal = laptopStore.write
+	log.Printf("loaded %d laptops at revision %d from the database", len(record.Saved), record.Revision)
+	return laptopStore, nil
+}
+
+// write is the journal of the in-memory store, it is called with the mutex locked
+func (store *BoltLaptopStore) write(record *pb.LaptopLogRecord) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(laptopBucket)
+		for _, laptop := range record.GetSaved() {
+			data, err := proto.Marshal(laptop)
+			if err != nil {
+				return fmt.Errorf("cannot marshal laptop:%w", err)
+			}
+
+			err = bucket.Put([]byte(laptop.Id), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, id := range record.GetDeletedIds() {
+			err := bucket.Delete([]byte(id))
+			if err != nil {
+				return err
+			}
+		}
+
+		revision := make([]byte, 8)
+		binary.BigEndian.PutUint64(revision, record.GetRevision())
+		return tx.Bucket(metaBucket).Put(laptopRevisionKey, revision)
+	})
+}
+
+type BoltUserStore struct {
+	db *bolt.DB
+}
+
+func (store *BoltStore) UserStore() *BoltUserStore {
+	return &BoltUserStore{db: store.db}
+}
+
+func (store *BoltUserStore) Save(user *User) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(userBucket)
+		if bucket.Get([]byte(user.Username)) != nil {
+			return ErrAlreadyExists
+		}
+
+		return putJSON(bucket, user.Username, user)
+	})
+}
+
+func (store *BoltUserStore) Find(username string) (*User, error) {
+	user := &User{}
+	found := false
+	err := store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = getJSON(tx.Bucket(userBucket), username, user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("User does not exist %v, Try creating the user first", username)
+	}
+
+	return user, nil
+}
+
+type BoltRatingStore struct {
+	db *bolt.DB
+}
+
+func (store *BoltStore) RatingStore() *BoltRatingStore {
+	return &BoltRatingStore{db: store.db}
+}
+
+// scoreKey is the key of the score of a user for a laptop, the IDs of the laptops never hold a zero byte
+func scoreKey(laptopID string, username string) string {
+	return laptopID + "\x00" + username
+}
+
+// Rate checks that the laptop exists and sets the score in the same transaction,
+// so a laptop deleted concurrently is never rated. It returns ErrNotFound if there is no such laptop
+func (store *BoltRatingStore) Rate(laptopID string, username string, score float64) (*Rating, bool, error) {
+	rating := &Rating{}
+	created := false
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(laptopBucket).Get([]byte(laptopID)) == nil {
+			return ErrNotFound
+		}
+
+		var previous float64
+		scores := tx.Bucket(scoreBucket)
+		found, err := getJSON(scores, scoreKey(laptopID, username), &previous)
+		if err != nil {
+			return err
+		}
+
+		ratings := tx.Bucket(ratingBucket)
+		_, err = getJSON(ratings, laptopID, rating)
+		if err != nil {
+			return err
+		}
+
+		created = !found
+		if found {
+			rating.replace(&previous, &score)
+		} else {
+			rating.replace(nil, &score)
+		}
+
+		err = putJSON(scores, scoreKey(laptopID, username), score)
+		if err != nil {
+			return err
+		}
+		return putJSON(ratings, laptopID, rating)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return rating, created, nil
+}
+
+func (store *BoltRatingStore) Retract(laptopID string, username string) (*Rating, error) {
+	rating := &Rating{}
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		var previous float64
+		scores := tx.Bucket(scoreBucket)
+		found, err := getJSON(scores, scoreKey(laptopID, username), &previous)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrNotFound
+		}
+
+		ratings := tx.Bucket(ratingBucket)
+		_, err = getJSON(ratings, laptopID, rating)
+		if err != nil {
+			return err
+		}
+		rating.replace(&previous, nil)
+
+		err = scores.Delete([]byte(scoreKey(laptopID, username)))
+		if err != nil {
+			return err
+		}
+		return putJSON(ratings, laptopID, rating)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rating, nil
+}
+
+func (store *BoltRatingStore) Find(laptopID string) (*Rating, error) {
+	rating := &Rating{}
+	found := false
+	err := store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = getJSON(tx.Bucket(ratingBucket), laptopID, rating)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return rating, nil
+}
+
+func (store *BoltRatingStore) List(found func(laptopID string, rating *Rating) error) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ratingBucket).ForEach(func(key, value []byte) error {
+			rating := &Rating{}
+			err := json.Unmarshal(value, rating)
+			if err != nil {
+				return fmt.Errorf("cannot unmarshal %s:%w", key, err)
+			}
+			return found(string(key), rating)
+		})
+	})
+}
+
+type BoltImageInfoStore struct {
+	db *bolt.DB
+}
+
+func (store *BoltStore) ImageInfoStore() *BoltImageInfoStore {
+	return &BoltImageInfoStore{db: store.db}
+}
+
+func (store *BoltImageInfoStore) Save(imageID string, info *ImageInfo) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(imageInfoBucket)
+		if bucket.Get([]byte(imageID)) != nil {
+			return ErrAlreadyExists
+		}
+
+		err := putJSON(bucket, imageID, info)
+		if err != nil || info.SHA256 == "" {
+			return err
+		}
+
+		blobs := tx.Bucket(imageBlobBucket)
+		return putReferences(blobs, info.SHA256, getReferences(blobs, info.SHA256)+1)
+	})
+}
+
+func (store *BoltImageInfoStore) Delete(imageID string) (*ImageInfo, uint64, error) {
+	info := &ImageInfo{}
+	var references uint64
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(imageInfoBucket)
+		found, err := getJSON(bucket, imageID, info)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrNotFound
+		}
+
+		err = bucket.Delete([]byte(imageID))
+		if err != nil || info.SHA256 == "" {
+			return err
+		}
+
+		blobs := tx.Bucket(imageBlobBucket)
+		references = getReferences(blobs, info.SHA256) - 1
+		if references == 0 {
+			return blobs.Delete([]byte(info.SHA256))
+		}
+		return putReferences(blobs, info.SHA256, references)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return info, references, nil
+}
+
+// getReferences returns the number of images referencing the blob
+func getReferences(blobs *bolt.Bucket, sha256 string) uint64 {
+	data := blobs.Get([]byte(sha256))
+	if data == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+func putReferences(blobs *bolt.Bucket, sha256 string, references uint64) error {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, references)
+	return blobs.Put([]byte(sha256), data)
+}
+
+// List reads the info of every image, the images of a laptop are only listed when it is deleted
+func (store *BoltImageInfoStore) List(laptopID string) ([]string, error) {
+	var imageIDs []string
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imageInfoBucket).ForEach(func(key, value []byte) error {
+			info := &ImageInfo{}
+			err := json.Unmarshal(value, info)
+			if err != nil {
+				return fmt.Errorf("cannot unmarshal %s:%w", key, err)
+			}
+			if info.LaptopID == laptopID {
+				imageIDs = append(imageIDs, string(key))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return imageIDs, nil
+}
+
+func (store *BoltImageInfoStore) Find(imageID string) (*ImageInfo, error) {
+	info := &ImageInfo{}
+	found := false
+	err := store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = getJSON(tx.Bucket(imageInfoBucket), imageID, info)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// BoltReviewStore keeps the reviews by ID, along with two kinds of keys in another bucket: the score key
+// of a laptop and a user to the ID of their review, and the keys of the reviews in the order they are listed
+type BoltReviewStore struct {
+	db *bolt.DB
+}
+
+func (store *BoltStore) ReviewStore() *BoltReviewStore {
+	return &BoltReviewStore{db: store.db}
+}
+
+// reviewListKey sorts the reviews of a laptop in the order of compareReviews, whose creation times are
+// truncated to microseconds. It can't be mistaken for a score key since its laptop ID is followed by two zero bytes
+func reviewListKey(review *pb.Review) []byte {
+	key := []byte(review.LaptopId + "\x00\x00")
+	key = binary.BigEndian.AppendUint64(key, ^uint64(review.GetCreatedAt().AsTime().UnixMicro()))
+	return append(key, review.Id...)
+}
+
+func (store *BoltReviewStore) Save(review *pb.Review) (bool, error) {
+	replaced := false
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		reviews := tx.Bucket(reviewBucket)
+		keys := tx.Bucket(reviewKeyBucket)
+		if reviews.Get([]byte(review.Id)) != nil {
+			return ErrAlreadyExists
+		}
+
+		key := []byte(scoreKey(review.LaptopId, review.Username))
+		previousID := keys.Get(key)
+		if previousID != nil {
+			previous, err := getReview(reviews, previousID)
+			if err != nil {
+				return err
+			}
+			if previous.Status == pb.Review_HIDDEN {
+				review.Status = pb.Review_HIDDEN
+			}
+
+			err = reviews.Delete(previousID)
+			if err != nil {
+				return err
+			}
+			err = keys.Delete(reviewListKey(previous))
+			if err != nil {
+				return err
+			}
+			replaced = true
+		}
+
+		err := putReview(reviews, review)
+		if err != nil {
+			return err
+		}
+		err = keys.Put(key, []byte(review.Id))
+		if err != nil {
+			return err
+		}
+		return keys.Put(reviewListKey(review), nil)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return replaced, nil
+}
+
+func (store *BoltReviewStore) Find(id string) (*pb.Review, error) {
+	var review *pb.Review
+	err := store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		review, err = getReview(tx.Bucket(reviewBucket), []byte(id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return review, nil
+}
+
+func (store *BoltReviewStore) SetStatus(id string, status pb.Review_Status) (*pb.Review, error) {
+	var review *pb.Review
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		reviews := tx.Bucket(reviewBucket)
+		var err error
+		review, err = getReview(reviews, []byte(id))
+		if err != nil {
+			return err
+		}
+		if review == nil {
+			return ErrNotFound
+		}
+
+		review.Status = status
+		return putReview(reviews, review)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return review, nil
+}
+
+func (store *BoltReviewStore) List(laptopID string, found func(review *pb.Review) error) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		reviews := tx.Bucket(reviewBucket)
+		prefix := []byte(laptopID + "\x00\x00")
+		cursor := tx.Bucket(reviewKeyBucket).Cursor()
+		for key, _ := cursor.Seek(prefix); bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			review, err := getReview(reviews, key[len(prefix)+8:])
+			if err != nil {
+				return err
+			}
+
+			err = found(review)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// getReview returns the review stored under the ID, or nil if there is none
+func getReview(reviews *bolt.Bucket, id []byte) (*pb.Review, error) {
+	data := reviews.Get(id)
+	if data == nil {
+		return nil, nil
+	}
+
+	review := &pb.Review{}
+	err := proto.Unmarshal(data, review)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal review %s:%w", id, err)
+	}
+	return review, nil
+}
+
+func putReview(reviews *bolt.Bucket, review *pb.Review) error {
+	data, err := proto.Marshal(review)
+	if err != nil {
+		return fmt.Errorf("cannot marshal review:%w", err)
+	}
+	return reviews.Put([]byte(review.Id), data)
+}
+
+func putJSON(bucket *bolt.Bucket, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("cannot marshal %s:%w", key, err)
+	}
+	return bucket.Put([]byte(key), data)
+}
+
+// getJSON decodes the value stored under key, it returns false if there is none
+func getJSON(bucket *bolt.Bucket, key string, value interface{}) (bool, error) {
+	data := bucket.Get([]byte(key))
+	if data == nil {
+		return false, nil
+	}
+
+	err := json.Unmarshal(data, value)
+	if err != nil {
+		return false, fmt.Errorf("cannot unmarshal %s:%w", key, err)
+	}
+	return true, nil
+}
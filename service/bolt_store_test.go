@@ -0,0 +1,100 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/moataz-hamed/pb/pb"
+	"github.com/moataz-hamed/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestBoltLaptopStoreVersion(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	store, err := db.LaptopStore()
+	require.NoError(t, err)
+
+	testLaptopStoreVersion(t, store)
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenBoltStore(path)
+	require.NoError(t, err)
+
+	laptopStore, err := db.LaptopStore()
+	require.NoError(t, err)
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	_, created, err := db.RatingStore().Rate(laptop.Id, "user1", 8)
+	require.NoError(t, err)
+	require.True(t, created)
+	_, _, err = db.RatingStore().Rate(sample.NewLaptop().Id, "user1", 8)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	user, err := NewUser("user1", "password", "user")
+	require.NoError(t, err)
+	require.NoError(t, db.UserStore().Save(user))
+	require.ErrorIs(t, db.UserStore().Save(user), ErrAlreadyExists)
+
+	require.NoError(t, db.ImageInfoStore().Save("image1", &ImageInfo{LaptopID: laptop.Id, Type: ".png"}))
+
+	review := &pb.Review{Id: "review1", LaptopId: laptop.Id, Username: "user1", Body: "body", Score: 8, CreatedAt: timestamppb.Now()}
+	_, err = db.ReviewStore().Save(review)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = OpenBoltStore(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	laptopStore, err = db.LaptopStore()
+	require.NoError(t, err)
+	requireStoredLaptop(t, laptopStore, laptop)
+
+	// the score of user1 is replaced
+	rating, created, err := db.RatingStore().Rate(laptop.Id, "user1", 6)
+	require.NoError(t, err)
+	require.False(t, created)
+	require.Equal(t, uint32(1), rating.Count)
+	require.Equal(t, 6.0, rating.Sum)
+
+	rating, _, err = db.RatingStore().Rate(laptop.Id, "user2", 8)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Count)
+	require.Equal(t, 14.0, rating.Sum)
+
+	rating, err = db.RatingStore().Retract(laptop.Id, "user1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), rating.Count)
+	require.Equal(t, 8.0, rating.Sum)
+	_, err = db.RatingStore().Retract(laptop.Id, "user1")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	found, err := db.UserStore().Find("user1")
+	require.NoError(t, err)
+	require.True(t, found.IsCorrectPassword("password"))
+
+	info, err := db.ImageInfoStore().Find("image1")
+	require.NoError(t, err)
+	require.Equal(t, laptop.Id, info.LaptopID)
+
+	// the reviews are kept along with the ratings they wrote
+	var reviews []*pb.Review
+	err = db.ReviewStore().List(laptop.Id, func(review *pb.Review) error {
+		reviews = append(reviews, review)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	require.Equal(t, review.Id, reviews[0].Id)
+}
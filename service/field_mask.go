@@ -0,0 +1,79 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// validateFieldMask checks that every path names a field of the message, nested fields are separated by dots
+// and the name of a oneof (e.g. "weight") can be used to replace whichever of its fields is set
+func validateFieldMask(desc protoreflect.MessageDescriptor, paths []string) error {
+	for _, path := range paths {
+		err := validateFieldPath(desc, path)
+		if err != nil {
+			return fmt.Errorf("invalid path %q: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func validateFieldPath(desc protoreflect.MessageDescriptor, path string) error {
+	segments := strings.Split(path, ".")
+	last := len(segments) - 1
+
+	for i, name := range segments {
+		field := desc.Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			if i == last && desc.Oneofs().ByName(protoreflect.Name(name)) != nil {
+				return nil
+			}
+			return fmt.Errorf("unknown field %q in %s", name, desc.Name())
+		}
+
+		if i == last {
+			return nil
+		}
+
+		if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+			return fmt.Errorf("field %q is not a message and has no sub fields", name)
+		}
+		desc = field.Message()
+	}
+	return nil
+}
+
+// applyFieldMask copies the fields named by the paths from src into dst,
+// a field that is not set in src is cleared in dst. The paths must be validated first
+func applyFieldMask(dst, src protoreflect.Message, paths []string) {
+	for _, path := range paths {
+		applyFieldPath(dst, src, strings.Split(path, "."))
+	}
+}
+
+func applyFieldPath(dst, src protoreflect.Message, segments []string) {
+	for _, name := range segments[:len(segments)-1] {
+		field := dst.Descriptor().Fields().ByName(protoreflect.Name(name))
+		dst = dst.Mutable(field).Message()
+		src = src.Get(field).Message()
+	}
+
+	name := protoreflect.Name(segments[len(segments)-1])
+	if oneof := dst.Descriptor().Oneofs().ByName(name); oneof != nil {
+		if field := dst.WhichOneof(oneof); field != nil {
+			dst.Clear(field)
+		}
+		if field := src.WhichOneof(oneof); field != nil {
+			dst.Set(field, src.Get(field))
+		}
+		return
+	}
+
+	field := dst.Descriptor().Fields().ByName(name)
+	if src.Has(field) {
+		dst.Set(field, src.Get(field))
+	} else {
+		dst.Clear(field)
+	}
+}
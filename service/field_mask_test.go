@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/moataz-hamed/pb/pb"
+	"github.com/moataz-hamed/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestApplyFieldMask(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	patch := &pb.Laptop{
+		PriceUsd: 999,
+		Cpu:      &pb.CPU{MaxGhz: 4.2, Name: "ignored"},
+		Weight:   &pb.Laptop_WeightLb{WeightLb: 3.5},
+	}
+	paths := []string{"price_usd", "cpu.max_ghz", "weight", "keyboard"}
+
+	err := validateFieldMask(laptop.ProtoReflect().Descriptor(), paths)
+	require.NoError(t, err)
+
+	expected := proto.Clone(laptop).(*pb.Laptop)
+	expected.PriceUsd = 999
+	expected.Cpu.MaxGhz = 4.2
+	expected.Weight = &pb.Laptop_WeightLb{WeightLb: 3.5}
+	expected.Keyboard = nil
+
+	applyFieldMask(laptop.ProtoReflect(), patch.ProtoReflect(), paths)
+	require.True(t, proto.Equal(expected, laptop))
+}
+
+func TestValidateFieldMask(t *testing.T) {
+	t.Parallel()
+
+	desc := (&pb.Laptop{}).ProtoReflect().Descriptor()
+
+	require.NoError(t, validateFieldMask(desc, []string{"screen.resolution.width", "weight_kg", "gpu"}))
+	require.ErrorContains(t, validateFieldMask(desc, []string{"price"}), `"price"`)
+	require.ErrorContains(t, validateFieldMask(desc, []string{"gpu.name"}), `"gpu.name"`)
+	require.ErrorContains(t, validateFieldMask(desc, []string{"brand.name"}), `"brand.name"`)
+}
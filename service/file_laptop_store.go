@@ -0,0 +1,277 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/moataz-hamed/pb/pb"
+	"github.com/moataz-hamed/serializer"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	laptopLogFile      = "laptops.wal"
+	laptopSnapshotFile = "laptops.snapshot"
+
+	// every log record is framed by its length and its CRC-32C checksum
+	logFrameHeaderSize = 8
+	maxLogRecordSize   = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileLaptopStore keeps the laptops in memory and makes them durable in a directory: every change is
+// appended to a write-ahead log before it is applied, and the log is compacted into a snapshot once it
+// holds snapshotEvery records. Both are replayed when the store is opened again
+type FileLaptopStore struct {
+	*InMemoryLaptopStore
+	dir           string
+	logFile       *os.File
+	logSize       int64 // the records are written at this offset, past the last complete one
+	logErr        error // set once a failed append can't be undone, the log refuses the next records
+	logRecords    int
+	snapshotEvery int
+}
+
+func NewFileLaptopStore(dir string, snapshotEvery int) (*FileLaptopStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create data directory:%w", err)
+	}
+
+	store := &FileLaptopStore{
+		InMemoryLaptopStore: NewInMemoryLaptopStore(),
+		dir:                 dir,
+		snapshotEvery:       snapshotEvery,
+	}
+
+	err = store.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.replayLog()
+	if err != nil {
+		return nil, err
+	}
+
+	store.journal = store.appendLog
+	log.Printf("loaded %d laptops at revision %d from %s", len(store.data), store.revision, dir)
+	return store, nil
+}
+
+// Close closes the write-ahead log, the store must not be used afterwards
+func (store *FileLaptopStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.logFile.Close()
+}
+
+func (store *FileLaptopStore) loadSnapshot() error {
+	snapshot := &pb.LaptopSnapshot{}
+	err := serializer.ReadProtobufToBinaryFile(filepath.Join(store.dir, laptopSnapshotFile), snapshot)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot load laptop snapshot:%w", err)
+	}
+
+	store.apply(&pb.LaptopLogRecord{Saved: snapshot.GetLaptops(), Revision: snapshot.GetRevision()})
+	return nil
+}
+
+// replayLog applies the records of the write-ahead log which are newer than the snapshot. A torn or corrupted
+// record at the end of the log is what a crash in the middle of an append leaves behind, so the log is
+// truncated right before it. A broken record followed by others is corruption, and the log is not opened
+func (store *FileLaptopStore) replayLog() error {
+	file, err := os.OpenFile(filepath.Join(store.dir, laptopLogFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open laptop log:%w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot stat laptop log:%w", err)
+	}
+
+	var offset int64
+	for {
+		record, size, err := readLogRecord(file)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if offset+size < info.Size() {
+				file.Close()
+				return fmt.Errorf("laptop log is corrupted at offset %d:%w", offset, err)
+			}
+
+			log.Printf("truncating laptop log at offset %d: %v", offset, err)
+			err = file.Truncate(offset)
+			if err != nil {
+				file.Close()
+				return fmt.Errorf("cannot truncate laptop log:%w", err)
+			}
+			break
+		}
+
+		if record.GetRevision() > store.revision {
+			store.apply(record)
+		}
+		offset += size
+		store.logRecords++
+	}
+
+	store.logFile = file
+	store.logSize = offset
+	return nil
+}
+
+// readLogRecord reads the next framed record and returns it with the number of bytes it took,
+// io.EOF is only returned when the log ends exactly between two records. When the record is broken
+// the size is the one its header claims, so the caller can tell whether it is the last one
+func readLogRecord(reader io.Reader) (*pb.LaptopLogRecord, int64, error) {
+	header := make([]byte, logFrameHeaderSize)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, logFrameHeaderSize, fmt.Errorf("torn record header")
+		}
+		return nil, 0, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	size := int64(logFrameHeaderSize) + int64(length)
+	if length > maxLogRecordSize {
+		return nil, size, fmt.Errorf("record length %d is too large", length)
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return nil, size, fmt.Errorf("torn record of %d bytes:%v", length, err)
+	}
+
+	if crc32.Checksum(data, crcTable) != checksum {
+		return nil, size, fmt.Errorf("record checksum mismatch")
+	}
+
+	record := &pb.LaptopLogRecord{}
+	err = proto.Unmarshal(data, record)
+	if err != nil {
+		return nil, size, fmt.Errorf("cannot unmarshal record:%v", err)
+	}
+
+	return record, size, nil
+}
+
+// appendLog is the journal of the in-memory store, it is called with the mutex locked. The log is compacted
+// before the record is appended, which is the last time the stored laptops match the snapshot revision.
+// Compacting is only an optimization, so the record is appended to the full log if it fails
+func (store *FileLaptopStore) appendLog(record *pb.LaptopLogRecord) error {
+	if store.logErr != nil {
+		return fmt.Errorf("laptop log is unusable:%w", store.logErr)
+	}
+
+	if store.snapshotEvery > 0 && store.logRecords >= store.snapshotEvery {
+		err := store.compact()
+		if err != nil {
+			// the log still holds every record the snapshot may miss, so the next attempt can wait for
+			// snapshotEvery more records instead of running before each one
+			log.Printf("cannot compact laptop log: %v", err)
+			store.logRecords = 0
+		}
+	}
+
+	data, err := proto.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("cannot marshal log record:%w", err)
+	}
+
+	frame := make([]byte, logFrameHeaderSize, logFrameHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(data, crcTable))
+	frame = append(frame, data...)
+
+	_, err = store.logFile.WriteAt(frame, store.logSize)
+	if err == nil {
+		err = store.logFile.Sync()
+	}
+	if err != nil {
+		// drop what may have been written, so the next records don't follow a torn one
+		truncateErr := store.logFile.Truncate(store.logSize)
+		if truncateErr != nil {
+			store.logErr = truncateErr
+			return fmt.Errorf("cannot append to laptop log:%w", errors.Join(err, truncateErr))
+		}
+		return fmt.Errorf("cannot append to laptop log:%w", err)
+	}
+
+	store.logSize += int64(len(frame))
+	store.logRecords++
+	return nil
+}
+
+// compact writes every stored laptop into a new snapshot and empties the log. The snapshot replaces the old one
+// atomically, if the log can't be emptied afterwards its records are skipped on replay thanks to their revision
+func (store *FileLaptopStore) compact() error {
+	snapshot := &pb.LaptopSnapshot{Revision: store.revision}
+	for _, laptop := range store.data {
+		snapshot.Laptops = append(snapshot.Laptops, laptop)
+	}
+
+	snapshotPath := filepath.Join(store.dir, laptopSnapshotFile)
+	tempPath := snapshotPath + ".tmp"
+	err := serializer.WriteProtobufToBinaryFile(snapshot, tempPath)
+	if err != nil {
+		return fmt.Errorf("cannot write laptop snapshot:%w", err)
+	}
+
+	err = syncFile(tempPath)
+	if err != nil {
+		return fmt.Errorf("cannot sync laptop snapshot:%w", err)
+	}
+
+	err = os.Rename(tempPath, snapshotPath)
+	if err != nil {
+		return fmt.Errorf("cannot replace laptop snapshot:%w", err)
+	}
+
+	// the rename is only durable once the directory is synced, the log must not be emptied before
+	err = syncFile(store.dir)
+	if err != nil {
+		return fmt.Errorf("cannot sync data directory:%w", err)
+	}
+
+	// the records left in the log if it can't be emptied are skipped by the replay, the snapshot holds their revision
+	err = store.logFile.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("cannot truncate laptop log:%w", err)
+	}
+
+	log.Printf("compacted %d log records into a snapshot of %d laptops", store.logRecords, len(snapshot.Laptops))
+	store.logSize = 0
+	store.logRecords = 0
+	return nil
+}
+
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
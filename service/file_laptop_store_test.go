@@ -0,0 +1,174 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moataz-hamed/pb/pb"
+	"github.com/moataz-hamed/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFileLaptopStoreVersion(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewFileLaptopStore(t.TempDir(), 2)
+	require.NoError(t, err)
+	defer store.Close()
+
+	testLaptopStoreVersion(t, store)
+}
+
+func TestFileLaptopStoreReopen(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store, err := NewFileLaptopStore(dir, 3)
+	require.NoError(t, err)
+
+	laptops := make([]*pb.Laptop, 5)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		require.NoError(t, store.Save(laptops[i]))
+	}
+
+	laptops[0].PriceUsd = 100
+	require.NoError(t, store.Update(laptops[0]))
+	require.NoError(t, store.Delete(laptops[1].Id, 0))
+	require.NoError(t, store.Close())
+
+	_, err = os.Stat(filepath.Join(dir, laptopSnapshotFile))
+	require.NoError(t, err)
+
+	store, err = NewFileLaptopStore(dir, 3)
+	require.NoError(t, err)
+	defer store.Close()
+
+	requireStoredLaptop(t, store, laptops[0])
+	found, err := store.Find(laptops[1].Id)
+	require.NoError(t, err)
+	require.Nil(t, found)
+	for _, laptop := range laptops[2:] {
+		requireStoredLaptop(t, store, laptop)
+	}
+
+	other := sample.NewLaptop()
+	require.NoError(t, store.Save(other))
+	require.Greater(t, other.Version, laptops[0].Version)
+}
+
+func TestFileLaptopStoreTruncatedLog(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store, err := NewFileLaptopStore(dir, 0)
+	require.NoError(t, err)
+
+	laptop1 := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop1))
+	laptop2 := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop2))
+	require.NoError(t, store.Close())
+
+	// simulate a crash in the middle of appending the second record
+	logPath := filepath.Join(dir, laptopLogFile)
+	info, err := os.Stat(logPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(logPath, info.Size()-5))
+
+	store, err = NewFileLaptopStore(dir, 0)
+	require.NoError(t, err)
+
+	requireStoredLaptop(t, store, laptop1)
+	found, err := store.Find(laptop2.Id)
+	require.NoError(t, err)
+	require.Nil(t, found)
+
+	// the torn tail is dropped so new records are appended right after the last good one
+	laptop3 := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop3))
+	require.NoError(t, store.Close())
+
+	store, err = NewFileLaptopStore(dir, 0)
+	require.NoError(t, err)
+	defer store.Close()
+
+	requireStoredLaptop(t, store, laptop1)
+	requireStoredLaptop(t, store, laptop3)
+}
+
+func TestFileLaptopStoreCorruptedLog(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store, err := NewFileLaptopStore(dir, 0)
+	require.NoError(t, err)
+
+	laptop1 := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop1))
+	laptop2 := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop2))
+	require.NoError(t, store.Close())
+
+	logPath := filepath.Join(dir, laptopLogFile)
+	data, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+
+	// a broken record followed by a good one is not a torn tail, dropping both would lose a saved laptop
+	corrupted := append([]byte(nil), data...)
+	corrupted[logFrameHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(logPath, corrupted, 0644))
+	_, err = NewFileLaptopStore(dir, 0)
+	require.ErrorContains(t, err, "corrupted at offset 0")
+
+	// the last record is only dropped
+	corrupted = append([]byte(nil), data...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(logPath, corrupted, 0644))
+	store, err = NewFileLaptopStore(dir, 0)
+	require.NoError(t, err)
+	defer store.Close()
+
+	requireStoredLaptop(t, store, laptop1)
+	found, err := store.Find(laptop2.Id)
+	require.NoError(t, err)
+	require.Nil(t, found)
+}
+
+func TestFileLaptopStoreCompactionFailure(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store, err := NewFileLaptopStore(dir, 1)
+	require.NoError(t, err)
+
+	// the snapshot can't be written over a directory
+	require.NoError(t, os.Mkdir(filepath.Join(dir, laptopSnapshotFile+".tmp"), 0755))
+
+	laptops := make([]*pb.Laptop, 3)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		require.NoError(t, store.Save(laptops[i]))
+	}
+	// a failed compaction is not tried again before the next record
+	require.Equal(t, 1, store.logRecords)
+	require.NoError(t, store.Close())
+	require.NoFileExists(t, filepath.Join(dir, laptopSnapshotFile))
+
+	store, err = NewFileLaptopStore(dir, 1)
+	require.NoError(t, err)
+	defer store.Close()
+
+	for _, laptop := range laptops {
+		requireStoredLaptop(t, store, laptop)
+	}
+}
+
+func requireStoredLaptop(t *testing.T, store LaptopStore, expected *pb.Laptop) {
+	found, err := store.Find(expected.Id)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	require.True(t, proto.Equal(expected, found))
+}
@@ -1,60 +1,332 @@
 package service
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 )
 
 type ImageStore interface {
-	Save(laptopID string, imageType string, imageData bytes.Buffer) (string, error)
+	// Save reads the image data until EOF and stores it, nothing is kept if reading the data fails.
+	// It tells whether the same data was already stored for another image, which then shares it
+	Save(laptopID string, imageType string, imageData io.Reader) (imageID string, deduplicated bool, err error)
+	// Open returns the info of the image and its data to be closed by the caller, or ErrNotFound if there is no such image.
+	// If variant is not 0 it is the variant of the image resized to fit in that many pixels which is returned
+	Open(imageID string, variant uint32) (*ImageInfo, io.ReadCloser, error)
+	// Delete removes the image, its data is only removed once no other image shares it.
+	// It returns ErrNotFound if there is no such image
+	Delete(imageID string) error
+	// DeleteLaptopImages removes every image of the laptop like Delete does
+	DeleteLaptopImages(laptopID string) error
 }
 
+// ImageInfoStore keeps the metadata of the images whose data is saved by an ImageStore, and counts
+// the images referencing each blob of data
+type ImageInfoStore interface {
+	// Save adds the image as a reference to the blob of its SHA256, if it has one
+	Save(imageID string, info *ImageInfo) error
+	Find(imageID string) (*ImageInfo, error)
+	// List returns the IDs of the images of the laptop
+	List(laptopID string) ([]string, error)
+	// Delete removes the image and returns its info with the number of images still referencing its blob,
+	// or ErrNotFound if there is no such image
+	Delete(imageID string) (info *ImageInfo, references uint64, err error)
+}
+
+// DiskImageStore names the files of the images by the SHA-256 digest of their data,
+// so the images with the same data share one file
 type DiskImageStore struct {
-	mutex       sync.RWMutex
-	imageFolder string
-	images      map[string]*ImageInfo
+	mutex          sync.Mutex // held while a blob or a variant is added or removed along with its references
+	imageFolder    string
+	infoStore      ImageInfoStore
+	variantSizes   []uint32
+	variantWorkers int
+	maxImagePixels int64
+	variants       chan *variantTask // generated by the workers
+	queueMutex     sync.RWMutex      // read locked to send to the variants, locked to close them
+	closed         atomic.Bool
+	workers        sync.WaitGroup
+	tasksMutex     sync.Mutex
+	tasks          map[string]*variantTask // path of a blob -> task queued or running for it
 }
 
 type ImageInfo struct {
 	LaptopID string
 	Type     string
 	Path     string
+	Size     int64
+	SHA256   string // hex digest of the data, empty for the images saved before their files were named by it
 }
 
-func NewDiskImageStore(imageFolder string) *DiskImageStore {
-	return &DiskImageStore{
-		imageFolder: imageFolder,
-		images:      make(map[string]*ImageInfo),
+func NewDiskImageStore(imageFolder string, infoStore ImageInfoStore, options ...DiskImageStoreOption) *DiskImageStore {
+	store := &DiskImageStore{
+		imageFolder:    imageFolder,
+		infoStore:      infoStore,
+		maxImagePixels: defaultMaxImagePixels,
+		tasks:          make(map[string]*variantTask),
+	}
+	for _, option := range options {
+		option(store)
+	}
+
+	// the variants are only ever generated by the workers, so there is at least one
+	if len(store.variantSizes) > 0 {
+		store.variants = make(chan *variantTask, variantQueueSize)
+		for range max(store.variantWorkers, 1) {
+			store.workers.Add(1)
+			go func() {
+				defer store.workers.Done()
+				store.generateVariants()
+			}()
+		}
 	}
+	return store
 }
 
-func (store *DiskImageStore) Save(laptopID string, imageType string, imageData bytes.Buffer) (string, error) {
+// Close stops the workers generating the variants once they finish the images they are resizing,
+// the variants of the images still queued are not generated
+func (store *DiskImageStore) Close() {
+	// the workers skip the remaining tasks, so the tasks waiting for room in the queue are sent
+	// and release the queue
+	if store.variants != nil && !store.closed.Swap(true) {
+		store.queueMutex.Lock()
+		close(store.variants)
+		store.queueMutex.Unlock()
+	}
+
+	store.workers.Wait()
+}
+
+func (store *DiskImageStore) Save(laptopID string, imageType string, imageData io.Reader) (string, bool, error) {
 	imageID, err := uuid.NewRandom()
 	if err != nil {
-		return "", fmt.Errorf("cannot generate image id:%w", err)
+		return "", false, fmt.Errorf("cannot generate image id:%w", err)
+	}
+
+	// the data is written to a temporary file in the same folder, which is only renamed
+	// to the path of its blob once all of it is written so no partial image can ever be opened
+	file, err := os.CreateTemp(store.imageFolder, imageID.String()+"-*.tmp")
+	if err != nil {
+		return "", false, fmt.Errorf("cannot create image file:%w", err)
+	}
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), imageData)
+	if err != nil {
+		file.Close()
+		return "", false, fmt.Errorf("cannot write image to file:%w", err)
 	}
-	imagePath := fmt.Sprintf("%s/%s%s", store.imageFolder, imageID, imageType)
-	file, err := os.Create(imagePath)
+
+	err = file.Sync()
 	if err != nil {
-		return "", fmt.Errorf("cannot create image file:%w", err)
+		file.Close()
+		return "", false, fmt.Errorf("cannot sync image file:%w", err)
 	}
-	_, err = imageData.WriteTo(file)
+
+	err = file.Close()
 	if err != nil {
-		return "", fmt.Errorf("cannot write image to file:%w", err)
+		return "", false, fmt.Errorf("cannot close image file:%w", err)
 	}
 
+	digest := hex.EncodeToString(hash.Sum(nil))
+	blobPath := fmt.Sprintf("%s/%s", store.imageFolder, digest)
+
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	store.images[imageID.String()] = &ImageInfo{
+	// an existing blob is kept, the temporary file is removed with the same data
+	_, err = os.Stat(blobPath)
+	deduplicated := err == nil
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.Rename(file.Name(), blobPath)
+		if err != nil {
+			return "", false, fmt.Errorf("cannot rename image file:%w", err)
+		}
+	} else if err != nil {
+		return "", false, fmt.Errorf("cannot stat image blob:%w", err)
+	}
+
+	err = store.infoStore.Save(imageID.String(), &ImageInfo{
 		LaptopID: laptopID,
 		Type:     imageType,
-		Path:     imagePath,
+		Path:     blobPath,
+		Size:     size,
+		SHA256:   digest,
+	})
+	if err != nil {
+		if !deduplicated {
+			os.Remove(blobPath)
+		}
+		return "", false, fmt.Errorf("cannot save image info:%w", err)
+	}
+
+	// the variants of a shared blob are already generated
+	if !deduplicated {
+		store.queueVariants(blobPath)
+	}
+	return imageID.String(), deduplicated, nil
+}
+
+func (store *DiskImageStore) Open(imageID string, variant uint32) (*ImageInfo, io.ReadCloser, error) {
+	info, err := store.infoStore.Find(imageID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot find image info:%w", err)
+	}
+	if info == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	if variant == 0 {
+		file, err := os.Open(info.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot open image file:%w", err)
+		}
+		return info, file, nil
+	}
+
+	file, err := store.openVariant(info.Path, variant)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("cannot stat image variant:%w", err)
+	}
+
+	// the variant has a data of its own, the digest of the image does not describe it
+	info.Path = file.Name()
+	info.Size = stat.Size()
+	info.SHA256 = ""
+	return info, file, nil
+}
+
+func (store *DiskImageStore) Delete(imageID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, references, err := store.infoStore.Delete(imageID)
+	if err != nil {
+		return fmt.Errorf("cannot delete image info:%w", err)
+	}
+	if references > 0 {
+		return nil
+	}
+
+	// the image is deleted once its info is, a file left behind is only reused by the next image with the same data
+	err = os.Remove(info.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("cannot remove image file %s: %v", info.Path, err)
+		return nil
+	}
+
+	err = store.removeVariants(info.Path)
+	if err != nil {
+		log.Printf("cannot remove variants of %s: %v", info.Path, err)
+	}
+	return nil
+}
+
+func (store *DiskImageStore) DeleteLaptopImages(laptopID string) error {
+	imageIDs, err := store.infoStore.List(laptopID)
+	if err != nil {
+		return fmt.Errorf("cannot list images:%w", err)
+	}
+
+	for _, imageID := range imageIDs {
+		err := store.Delete(imageID)
+		// the image may have been deleted since it was listed
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+type InMemoryImageInfoStore struct {
+	mutex      sync.RWMutex
+	images     map[string]*ImageInfo
+	references map[string]uint64 // SHA256 of a blob -> number of images referencing it
+}
+
+func NewInMemoryImageInfoStore() *InMemoryImageInfoStore {
+	return &InMemoryImageInfoStore{
+		images:     make(map[string]*ImageInfo),
+		references: make(map[string]uint64),
+	}
+}
+
+func (store *InMemoryImageInfoStore) Save(imageID string, info *ImageInfo) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.images[imageID] != nil {
+		return ErrAlreadyExists
+	}
+
+	other := *info
+	store.images[imageID] = &other
+	if other.SHA256 != "" {
+		store.references[other.SHA256]++
+	}
+	return nil
+}
+
+func (store *InMemoryImageInfoStore) Delete(imageID string) (*ImageInfo, uint64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info := store.images[imageID]
+	if info == nil {
+		return nil, 0, ErrNotFound
+	}
+	delete(store.images, imageID)
+
+	// the images saved without a digest have a file of their own
+	if info.SHA256 == "" {
+		return info, 0, nil
+	}
+
+	store.references[info.SHA256]--
+	references := store.references[info.SHA256]
+	if references == 0 {
+		delete(store.references, info.SHA256)
+	}
+	return info, references, nil
+}
+
+func (store *InMemoryImageInfoStore) List(laptopID string) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var imageIDs []string
+	for imageID, info := range store.images {
+		if info.LaptopID == laptopID {
+			imageIDs = append(imageIDs, imageID)
+		}
+	}
+	return imageIDs, nil
+}
+
+func (store *InMemoryImageInfoStore) Find(imageID string) (*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	info := store.images[imageID]
+	if info == nil {
+		return nil, nil
 	}
 
-	return imageID.String(), nil
+	other := *info
+	return &other, nil
 }
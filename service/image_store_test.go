@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskImageStoreDeduplication(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	infoStores := map[string]ImageInfoStore{
+		"memory": NewInMemoryImageInfoStore(),
+		"bolt":   db.ImageInfoStore(),
+	}
+	for name, infoStore := range infoStores {
+		t.Run(name, func(t *testing.T) {
+			testDiskImageStoreDeduplication(t, infoStore)
+		})
+	}
+}
+
+func testDiskImageStoreDeduplication(t *testing.T, infoStore ImageInfoStore) {
+	imageFolder := t.TempDir()
+	store := NewDiskImageStore(imageFolder, infoStore)
+	data := []byte("the same photo")
+
+	imageID1, deduplicated, err := store.Save("laptop1", ".png", bytes.NewReader(data))
+	require.NoError(t, err)
+	require.False(t, deduplicated)
+
+	imageID2, deduplicated, err := store.Save("laptop2", ".jpg", bytes.NewReader(data))
+	require.NoError(t, err)
+	require.True(t, deduplicated)
+
+	_, deduplicated, err = store.Save("laptop2", ".png", bytes.NewReader([]byte("another photo")))
+	require.NoError(t, err)
+	require.False(t, deduplicated)
+
+	info1, file, err := store.Open(imageID1, 0)
+	require.NoError(t, err)
+	file.Close()
+	info2, file, err := store.Open(imageID2, 0)
+	require.NoError(t, err)
+	file.Close()
+	require.Equal(t, info1.Path, info2.Path)
+	require.Equal(t, info1.SHA256, info2.SHA256)
+	require.Equal(t, "laptop2", info2.LaptopID)
+	require.Equal(t, ".jpg", info2.Type)
+
+	entries, err := os.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// the blob is kept while another image references it
+	require.NoError(t, store.Delete(imageID1))
+	_, _, err = store.Open(imageID1, 0)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, file, err = store.Open(imageID2, 0)
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	file.Close()
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+
+	require.NoError(t, store.Delete(imageID2))
+	require.NoFileExists(t, info2.Path)
+	require.ErrorIs(t, store.Delete(imageID2), ErrNotFound)
+
+	entries, err = os.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// deleting the images of a laptop keeps the blobs other laptops reference
+	shared, _, err := store.Save("laptop3", ".png", bytes.NewReader(data))
+	require.NoError(t, err)
+	_, _, err = store.Save("laptop3", ".png", bytes.NewReader([]byte("a third photo")))
+	require.NoError(t, err)
+	kept, _, err := store.Save("laptop4", ".png", bytes.NewReader(data))
+	require.NoError(t, err)
+
+	require.NoError(t, store.DeleteLaptopImages("laptop3"))
+	imageIDs, err := infoStore.List("laptop3")
+	require.NoError(t, err)
+	require.Empty(t, imageIDs)
+	_, _, err = store.Open(shared, 0)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, file, err = store.Open(kept, 0)
+	require.NoError(t, err)
+	file.Close()
+
+	entries, err = os.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestDiskImageStoreVariants(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	store := NewDiskImageStore(imageFolder, NewInMemoryImageInfoStore(), WithImageVariants([]uint32{8, 16}, 2))
+	defer store.Close()
+
+	var buffer bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buffer, image.NewGray(image.Rect(0, 0, 64, 32)), nil))
+	jpegID, _, err := store.Save("laptop1", ".jpg", &buffer)
+	require.NoError(t, err)
+
+	// the workers generate the variants in the background
+	info, file, err := store.Open(jpegID, 0)
+	require.NoError(t, err)
+	file.Close()
+	require.Eventually(t, func() bool {
+		_, err8 := os.Stat(variantPath(info.Path, 8))
+		_, err16 := os.Stat(variantPath(info.Path, 16))
+		return err8 == nil && err16 == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	variant, file, err := store.Open(jpegID, 8)
+	require.NoError(t, err)
+	config, format, err := image.DecodeConfig(file)
+	file.Close()
+	require.NoError(t, err)
+	require.Equal(t, "jpeg", format)
+	require.Equal(t, 8, config.Width)
+	require.Equal(t, 4, config.Height)
+	require.Empty(t, variant.SHA256)
+	require.Equal(t, ".jpg", variant.Type)
+
+	_, _, err = store.Open(jpegID, 32)
+	require.ErrorIs(t, err, ErrUnknownVariant)
+
+	// the variants missing are generated once opened
+	text, _, err := store.Save("laptop1", ".txt", bytes.NewReader([]byte("not an image")))
+	require.NoError(t, err)
+	_, _, err = store.Open(text, 8)
+	require.ErrorIs(t, err, ErrNoVariants)
+	require.NoError(t, store.Delete(text))
+
+	withoutWorkers := NewDiskImageStore(imageFolder, NewInMemoryImageInfoStore(), WithImageVariants([]uint32{4}, 0))
+	defer withoutWorkers.Close()
+	pngID, _, err := withoutWorkers.Save("laptop1", ".png", bytes.NewReader(newPNG(t, 2, 6)))
+	require.NoError(t, err)
+	_, file, err = withoutWorkers.Open(pngID, 4)
+	require.NoError(t, err)
+	config, format, err = image.DecodeConfig(file)
+	file.Close()
+	require.NoError(t, err)
+	require.Equal(t, "png", format)
+	require.Equal(t, 1, config.Width)
+	require.Equal(t, 4, config.Height)
+
+	// the variants are removed with their blob
+	require.NoError(t, withoutWorkers.Delete(pngID))
+	require.NoError(t, store.Delete(jpegID))
+	entries, err := os.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestDiskImageStoreVariantLimits(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	store := NewDiskImageStore(imageFolder, NewInMemoryImageInfoStore(), WithImageVariants([]uint32{4}, 1), WithMaxImagePixels(100))
+	defer store.Close()
+
+	// only the header of the image is read, it would take 40GB once decoded
+	imageID, _, err := store.Save("laptop1", ".png", bytes.NewReader(newPNGHeader(100000, 100000)))
+	require.NoError(t, err)
+	_, _, err = store.Open(imageID, 4)
+	require.ErrorIs(t, err, ErrTooManyPixels)
+
+	// a PNG image whose header is cut is corrupted, it is still a PNG image
+	imageID, _, err = store.Save("laptop1", ".png", bytes.NewReader(newPNGHeader(10, 10)[:20]))
+	require.NoError(t, err)
+	_, _, err = store.Open(imageID, 4)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNoVariants)
+
+	imageID, _, err = store.Save("laptop1", ".png", bytes.NewReader(newPNG(t, 10, 10)))
+	require.NoError(t, err)
+	_, file, err := store.Open(imageID, 4)
+	require.NoError(t, err)
+	file.Close()
+}
+
+func TestDiskImageStoreVariantTasks(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	store := NewDiskImageStore(imageFolder, NewInMemoryImageInfoStore())
+	imageID, _, err := store.Save("laptop1", ".png", bytes.NewReader(newPNG(t, 8, 8)))
+	require.NoError(t, err)
+	info, file, err := store.Open(imageID, 0)
+	require.NoError(t, err)
+	file.Close()
+
+	// the workers are started by hand so the tasks stay queued until then
+	store.variantSizes = []uint32{4}
+	store.variants = make(chan *variantTask, 1)
+
+	task := store.startVariants(info.Path, true)
+	require.Same(t, task, store.startVariants(info.Path, true))
+	require.Nil(t, store.startVariants(info.Path+"-other", false))
+	require.Len(t, store.variants, 1)
+
+	opened := make(chan error, 10)
+	for range 10 {
+		go func() {
+			file, err := store.openVariant(info.Path, 4)
+			if err == nil {
+				file.Close()
+			}
+			opened <- err
+		}()
+	}
+
+	store.workers.Add(1)
+	go func() {
+		defer store.workers.Done()
+		store.generateVariants()
+	}()
+	for range 10 {
+		require.NoError(t, <-opened)
+	}
+	<-task.done
+	require.NoError(t, task.err)
+	require.FileExists(t, variantPath(info.Path, 4))
+
+	// the workers are over once the store is closed, the variants are no longer generated
+	store.Close()
+	require.NoError(t, os.Remove(variantPath(info.Path, 4)))
+	_, err = store.openVariant(info.Path, 4)
+	require.ErrorIs(t, err, ErrImageStoreClosed)
+	require.Empty(t, store.tasks)
+}
+
+// newPNGHeader returns the signature and the header chunk of a PNG image of the dimensions, without its data
+func newPNGHeader(width uint32, height uint32) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, 13)
+	chunk = append(chunk, "IHDR"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, width)
+	chunk = binary.BigEndian.AppendUint32(chunk, height)
+	chunk = append(chunk, 8, 6, 0, 0, 0) // 8 bits RGBA, no interlacing
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	return append([]byte("\x89PNG\r\n\x1a\n"), chunk...)
+}
+
+func TestResize(t *testing.T) {
+	t.Parallel()
+
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		img.SetGray(x, 0, color.Gray{Y: uint8(x * 60)})
+		img.SetGray(x, 1, color.Gray{Y: uint8(x * 60)})
+	}
+
+	resized := resize(img, 2)
+	require.Equal(t, image.Rect(0, 0, 2, 1), resized.Bounds())
+	require.Equal(t, color.RGBA{R: 30, G: 30, B: 30, A: 255}, resized.At(0, 0))
+	require.Equal(t, color.RGBA{R: 150, G: 150, B: 150, A: 255}, resized.At(1, 0))
+
+	// the images which already fit are kept at their size
+	require.Equal(t, img.Bounds(), resize(img, 8).Bounds())
+}
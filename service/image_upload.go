@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+
+	"github.com/moataz-hamed/pb/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// by default the uploaded images are limited to 1 MegaByte
+const defaultMaxImageSize = 1 << 20
+
+// imageChunkReader reads the image data from the chunks received on an upload stream,
+// so they can be written to the image store as they arrive
+type imageChunkReader struct {
+	stream  pb.LaptopService_UploadImageServer
+	maxSize int64
+	size    int64
+	chunk   []byte // what is left of the last received chunk
+	err     error  // status of the error which ended the reading, to be returned to the client
+}
+
+func (reader *imageChunkReader) Read(p []byte) (int, error) {
+	for len(reader.chunk) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+
+		err := contextError(reader.stream.Context())
+		if err != nil {
+			reader.err = err
+			return 0, err
+		}
+
+		req, err := reader.stream.Recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			reader.err = logError(status.Errorf(codes.Unknown, "can't receive chunk data %v", err))
+			return 0, reader.err
+		}
+
+		reader.chunk = req.GetChunkData()
+		reader.size += int64(len(reader.chunk))
+		if reader.size > reader.maxSize {
+			reader.chunk = nil
+			reader.err = logError(status.Errorf(codes.InvalidArgument, "Image is too large, Max image size is:%d", reader.maxSize))
+			return 0, reader.err
+		}
+	}
+
+	n := copy(p, reader.chunk)
+	reader.chunk = reader.chunk[n:]
+	return n, nil
+}
+
+// WithUploadStore enables the resumable uploads, keeping their sessions in the store
+func WithUploadStore(uploadStore UploadStore) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.uploadStore = uploadStore
+	}
+}
+
+func (server *LaptopServer) checkUploadsEnabled() error {
+	if server.uploadStore == nil {
+		return status.Errorf(codes.Unimplemented, "resumable uploads are not enabled")
+	}
+	return nil
+}
+
+// StartUpload opens an upload session for an image of the laptop
+func (server *LaptopServer) StartUpload(ctx context.Context, in *pb.StartUploadRequest) (*pb.StartUploadResponse, error) {
+	err := server.checkUploadsEnabled()
+	if err != nil {
+		return nil, err
+	}
+
+	laptopID := in.GetInfo().GetLaptopId()
+	imageType := in.GetInfo().GetImageTypes()
+	log.Printf("receive a start upload request for laptop %s with image type %s", laptopID, imageType)
+
+	laptop, err := server.LaptopStore.Find(laptopID)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "Can't find laptop: %v", err))
+	}
+	if laptop == nil {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "laptop %s does not exist", laptopID))
+	}
+
+	uploadID, err := server.uploadStore.Start(laptopID, imageType, in.GetInfo().GetSha256())
+	if errors.Is(err, ErrTooManyUploads) {
+		return nil, logError(status.Errorf(codes.ResourceExhausted, "can't start upload: %v", err))
+	}
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "can't start upload: %v", err))
+	}
+	return &pb.StartUploadResponse{UploadId: uploadID}, nil
+}
+
+// UploadChunks appends the received chunks to their uploads, the chunks written before an error are kept
+func (server *LaptopServer) UploadChunks(stream pb.LaptopService_UploadChunksServer) error {
+	err := server.checkUploadsEnabled()
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	for {
+		err := contextError(stream.Context())
+		if err != nil {
+			return err
+		}
+
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return logError(status.Errorf(codes.Unknown, "can't receive chunk data %v", err))
+		}
+
+		chunk := req.GetChunkData()
+		if req.GetOffset()+uint64(len(chunk)) > uint64(server.maxImageSize) {
+			return logError(status.Errorf(codes.InvalidArgument, "Image is too large, Max image size is:%d", server.maxImageSize))
+		}
+
+		offset, err = server.uploadStore.Write(req.GetUploadId(), int64(req.GetOffset()), chunk)
+		if errors.Is(err, ErrOffsetMismatch) {
+			return logError(status.Errorf(codes.FailedPrecondition, "chunk offset %d does not match the uploaded size %d", req.GetOffset(), offset))
+		}
+		if err != nil {
+			return logError(status.Errorf(storeErrorCode(err), "can't write chunk data: %v", err))
+		}
+	}
+
+	return stream.SendAndClose(&pb.UploadChunkResponse{Offset: uint64(offset)})
+}
+
+// QueryUpload returns the size uploaded so far, where the next chunk must start
+func (server *LaptopServer) QueryUpload(ctx context.Context, in *pb.QueryUploadRequest) (*pb.QueryUploadResponse, error) {
+	err := server.checkUploadsEnabled()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := server.uploadStore.Find(in.GetUploadId())
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "can't find upload: %v", err))
+	}
+	if session == nil {
+		return nil, status.Errorf(codes.NotFound, "upload %s does not exist", in.GetUploadId())
+	}
+
+	res := &pb.QueryUploadResponse{
+		Offset:     uint64(session.Offset),
+		ExpireTime: timestamppb.New(session.ExpiresAt),
+	}
+	return res, nil
+}
+
+// FinalizeUpload saves the uploaded data to the image store once all of it is uploaded
+func (server *LaptopServer) FinalizeUpload(ctx context.Context, in *pb.FinalizeUploadRequest) (*pb.UploadImageResponse, error) {
+	err := server.checkUploadsEnabled()
+	if err != nil {
+		return nil, err
+	}
+
+	uploadID := in.GetUploadId()
+	log.Printf("receive a finalize upload request for upload %s", uploadID)
+
+	var imageID string
+	var deduplicated bool
+	var imageData *imageVerifier
+	err = server.uploadStore.Finish(uploadID, int64(in.GetSize()), func(session *UploadSession, data io.Reader) error {
+		var err error
+		imageData = server.newImageVerifier(data, session.Type, session.SHA256)
+		imageID, deduplicated, err = server.ImageStore.Save(session.LaptopID, session.Type, imageData)
+		return err
+	})
+	if imageData != nil && imageData.err != nil {
+		return nil, imageData.err
+	}
+	if errors.Is(err, ErrOffsetMismatch) {
+		return nil, logError(status.Errorf(codes.FailedPrecondition, "upload %s is not complete", uploadID))
+	}
+	if err != nil {
+		return nil, logError(status.Errorf(storeErrorCode(err), "can't save image to the store: %v", err))
+	}
+
+	log.Printf("saved image with id:%v and size: %d", imageID, in.GetSize())
+	return &pb.UploadImageResponse{Id: imageID, Size: in.GetSize(), Deduplicated: deduplicated}, nil
+}
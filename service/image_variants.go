@@ -0,0 +1,302 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ErrUnknownVariant is returned when an image is opened in a size which is not one of its variants
+var ErrUnknownVariant = errors.New("image variant is not configured")
+
+// ErrNoVariants is returned when a variant is opened for an image which is not a PNG or JPEG image
+var ErrNoVariants = errors.New("only PNG and JPEG images have variants")
+
+// ErrImageStoreClosed is returned when a variant is opened after the store is closed
+var ErrImageStoreClosed = errors.New("image store is closed")
+
+// ErrTooManyPixels is returned when a variant is opened for an image too large to be decoded
+var ErrTooManyPixels = errors.New("image has too many pixels to be resized")
+
+// by default the images of up to 50 megapixels are resized
+const defaultMaxImagePixels = 50_000_000
+
+// number of new images waiting for their variants, the variants of the next ones are only
+// generated once they are opened
+const variantQueueSize = 1024
+
+const variantJPEGQuality = 85
+
+// DiskImageStoreOption changes the default configuration of a DiskImageStore
+type DiskImageStoreOption func(store *DiskImageStore)
+
+// WithImageVariants generates for every new PNG or JPEG image a variant resized to fit in each of the sizes
+// in pixels, in the given number of workers, at least one. A variant which is not generated yet is generated
+// by the workers once it is opened
+func WithImageVariants(sizes []uint32, workers int) DiskImageStoreOption {
+	return func(store *DiskImageStore) {
+		store.variantSizes = sizes
+		store.variantWorkers = workers
+	}
+}
+
+// WithMaxImagePixels sets the number of pixels above which the images are not decoded to be resized,
+// the size of their data does not bound the memory they take once decoded
+func WithMaxImagePixels(pixels int64) DiskImageStoreOption {
+	return func(store *DiskImageStore) {
+		store.maxImagePixels = pixels
+	}
+}
+
+// variantTask generates the variants of a blob, the openings of its variants wait for the same task
+type variantTask struct {
+	path string
+	done chan struct{} // closed once the task is over
+	err  error
+}
+
+// variantPath is the path of the variant of a blob, next to it
+func variantPath(path string, size uint32) string {
+	return fmt.Sprintf("%s-%dpx", path, size)
+}
+
+// generateVariants runs the queued tasks until the store is closed
+func (store *DiskImageStore) generateVariants() {
+	for task := range store.variants {
+		if store.closed.Load() {
+			store.finishVariants(task, ErrImageStoreClosed)
+			continue
+		}
+
+		err := store.writeVariants(task.path, store.variantSizes)
+		if err != nil && !errors.Is(err, ErrNoVariants) {
+			log.Printf("cannot generate variants of %s: %v", task.path, err)
+		}
+		store.finishVariants(task, err)
+	}
+}
+
+// finishVariants ends the task with its error, the next openings of the variants of its blob start a new one
+func (store *DiskImageStore) finishVariants(task *variantTask, err error) {
+	task.err = err
+	store.tasksMutex.Lock()
+	delete(store.tasks, task.path)
+	store.tasksMutex.Unlock()
+	close(task.done)
+}
+
+// startVariants returns the task generating the variants of the blob, queuing it unless it is already running.
+// If wait is false the task is only queued if it does not wait for room in the queue, nil is returned otherwise.
+// Once the store is closed the task is over as soon as it is returned
+func (store *DiskImageStore) startVariants(path string, wait bool) *variantTask {
+	store.tasksMutex.Lock()
+	task := store.tasks[path]
+	if task != nil {
+		store.tasksMutex.Unlock()
+		return task
+	}
+	task = &variantTask{path: path, done: make(chan struct{})}
+	store.tasks[path] = task
+	store.tasksMutex.Unlock()
+
+	// the queue is only closed once no task is being sent to it
+	store.queueMutex.RLock()
+	defer store.queueMutex.RUnlock()
+
+	if store.closed.Load() {
+		store.finishVariants(task, ErrImageStoreClosed)
+		if !wait {
+			return nil
+		}
+		return task
+	}
+
+	if wait {
+		store.variants <- task
+		return task
+	}
+
+	select {
+	case store.variants <- task:
+		return task
+	default:
+		store.tasksMutex.Lock()
+		delete(store.tasks, path)
+		store.tasksMutex.Unlock()
+		return nil
+	}
+}
+
+// queueVariants asks the workers to generate the variants of the blob, without waiting for them
+func (store *DiskImageStore) queueVariants(path string) {
+	if store.variants == nil {
+		return
+	}
+
+	if store.startVariants(path, false) == nil {
+		log.Printf("too many images waiting for their variants, the variants of %s are generated once opened", path)
+	}
+}
+
+// openVariant opens the variant of the blob, waiting for the workers to generate it if it does not exist yet
+func (store *DiskImageStore) openVariant(path string, size uint32) (*os.File, error) {
+	if store.variants == nil || !slices.Contains(store.variantSizes, size) {
+		return nil, ErrUnknownVariant
+	}
+
+	file, err := os.Open(variantPath(path, size))
+	if errors.Is(err, os.ErrNotExist) {
+		task := store.startVariants(path, true)
+		<-task.done
+		if task.err != nil {
+			return nil, task.err
+		}
+		file, err = os.Open(variantPath(path, size))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open image variant:%w", err)
+	}
+	return file, nil
+}
+
+// writeVariants decodes the blob once and writes its variants of the sizes
+func (store *DiskImageStore) writeVariants(path string, sizes []uint32) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open image file:%w", err)
+	}
+	defer file.Close()
+
+	// only the header is read to find out the format and the dimensions of the image before it is decoded
+	config, format, err := image.DecodeConfig(file)
+	if err != nil && !errors.Is(err, image.ErrFormat) {
+		return fmt.Errorf("cannot decode image config:%w", err)
+	}
+	if format != "png" && format != "jpeg" {
+		return ErrNoVariants
+	}
+	if int64(config.Width)*int64(config.Height) > store.maxImagePixels {
+		return ErrTooManyPixels
+	}
+
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return fmt.Errorf("cannot seek image file:%w", err)
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return fmt.Errorf("cannot decode image:%w", err)
+	}
+
+	for _, size := range sizes {
+		err := store.writeVariant(path, size, resize(img, int(size)), format)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeVariant encodes the resized image to a temporary file which is renamed to the path of the variant,
+// unless the blob has been removed in the meantime
+func (store *DiskImageStore) writeVariant(path string, size uint32, img image.Image, format string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "variant-*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create variant file:%w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if format == "png" {
+		err = png.Encode(file, img)
+	} else {
+		err = jpeg.Encode(file, img, &jpeg.Options{Quality: variantJPEGQuality})
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot encode image variant:%w", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("cannot close variant file:%w", err)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, err = os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot stat image file:%w", err)
+	}
+
+	err = os.Rename(file.Name(), variantPath(path, size))
+	if err != nil {
+		return fmt.Errorf("cannot rename variant file:%w", err)
+	}
+	return nil
+}
+
+// removeVariants removes the variants of a blob, the mutex of the store must be locked by the caller
+func (store *DiskImageStore) removeVariants(path string) error {
+	for _, size := range store.variantSizes {
+		err := os.Remove(variantPath(path, size))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cannot remove image variant:%w", err)
+		}
+	}
+	return nil
+}
+
+// resize scales the image down to fit in size pixels, keeping its aspect ratio. Every pixel of the result
+// is the average of the pixels it covers in the image, the smaller images are only copied
+func resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(height*size/bounds.Dx(), 1)
+		} else {
+			width, height = max(width*size/bounds.Dy(), 1), size
+		}
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		top := bounds.Min.Y + y*bounds.Dy()/height
+		bottom := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, top+1)
+
+		for x := 0; x < width; x++ {
+			left := bounds.Min.X + x*bounds.Dx()/width
+			right := max(bounds.Min.X+(x+1)*bounds.Dx()/width, left+1)
+
+			// the colors are premultiplied by their alpha, so their average is not skewed by transparent pixels
+			var r, g, b, a uint64
+			for sy := top; sy < bottom; sy++ {
+				for sx := left; sx < right; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+				}
+			}
+
+			// the 16 bits channels are averaged and then reduced to 8 bits
+			count := uint64((bottom - top) * (right - left))
+			resized.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / count >> 8),
+				G: uint8(g / count >> 8),
+				B: uint8(b / count >> 8),
+				A: uint8(a / count >> 8),
+			})
+		}
+	}
+	return resized
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// content types of the images which can be uploaded by default
+var defaultImageContentTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// number of bytes http.DetectContentType looks at
+const sniffLength = 512
+
+// imageVerifier checks the data of an uploaded image as it is read: its content type is sniffed from
+// its first bytes and its SHA-256 digest is compared to the expected one once all of it is read
+type imageVerifier struct {
+	reader       io.Reader
+	imageType    string
+	contentTypes map[string]bool
+	sha256       string // expected hex digest, not checked if empty
+	hash         hash.Hash
+	head         []byte // first bytes of the data until the content type is sniffed
+	sniffed      bool
+	err          error // status of the error which ended the reading, to be returned to the client
+}
+
+func (server *LaptopServer) newImageVerifier(reader io.Reader, imageType string, sha256Digest string) *imageVerifier {
+	return &imageVerifier{
+		reader:       reader,
+		imageType:    imageType,
+		contentTypes: server.imageContentTypes,
+		sha256:       strings.ToLower(sha256Digest),
+		hash:         sha256.New(),
+	}
+}
+
+func (verifier *imageVerifier) Read(p []byte) (int, error) {
+	if verifier.err != nil {
+		return 0, verifier.err
+	}
+
+	n, err := verifier.reader.Read(p)
+	verifier.hash.Write(p[:n])
+
+	if !verifier.sniffed {
+		verifier.head = append(verifier.head, p[:min(n, sniffLength-len(verifier.head))]...)
+		// the content type is checked as soon as possible, so nothing more is read from an image which is refused
+		if len(verifier.head) == sniffLength || err == io.EOF {
+			verifier.sniffed = true
+			verifier.err = verifier.checkContentType()
+			if verifier.err != nil {
+				return 0, verifier.err
+			}
+		}
+	}
+
+	if err == io.EOF {
+		verifier.err = verifier.checkDigest()
+		if verifier.err != nil {
+			return 0, verifier.err
+		}
+	}
+	return n, err
+}
+
+// checkContentType refuses the images whose content is not of an allowed type or not of the type of their extension
+func (verifier *imageVerifier) checkContentType() error {
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(verifier.head))
+	if !verifier.contentTypes[contentType] {
+		return logError(status.Errorf(codes.InvalidArgument, "content type %s is not allowed", contentType))
+	}
+
+	// the extensions unknown to the mime package are kept as they are
+	extensionType, _, err := mime.ParseMediaType(mime.TypeByExtension(verifier.imageType))
+	if err == nil && extensionType != contentType {
+		return logError(status.Errorf(codes.InvalidArgument, "image type %s does not match its content type %s", verifier.imageType, contentType))
+	}
+	return nil
+}
+
+func (verifier *imageVerifier) checkDigest() error {
+	if verifier.sha256 == "" {
+		return nil
+	}
+
+	digest := hex.EncodeToString(verifier.hash.Sum(nil))
+	if digest != verifier.sha256 {
+		return logError(status.Errorf(codes.DataLoss, "image SHA-256 %s does not match the expected %s", digest, verifier.sha256))
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/moataz-hamed/pb/pb"
+)
+
+// facetRange is a bucket of a range facet, it holds the values from its min up to the min of the next one
+type facetRange struct {
+	label string
+	min   float64
+}
+
+var ramRanges = []facetRange{
+	{label: "0-8GB", min: 0},
+	{label: "8GB-16GB", min: gigabytes(8)},
+	{label: "16GB-32GB", min: gigabytes(16)},
+	{label: "32GB-64GB", min: gigabytes(32)},
+	{label: "64GB+", min: gigabytes(64)},
+}
+
+var priceRanges = []facetRange{
+	{label: "0-500", min: 0},
+	{label: "500-1000", min: 500},
+	{label: "1000-1500", min: 1000},
+	{label: "1500-2000", min: 1500},
+	{label: "2000+", min: 2000},
+}
+
+func gigabytes(value uint32) float64 {
+	return float64(toBit(&pb.Memory{Value: value, Unit: pb.Memory_GIGABYTE}))
+}
+
+// laptopFacets counts the laptops added to it by the values of their facets
+type laptopFacets struct {
+	total     uint32
+	brands    *foldedCounts
+	cpuBrands *foldedCounts
+	drivers   map[string]uint32
+	panels    map[string]uint32
+	ramSizes  []uint32
+	prices    []uint32
+}
+
+func newLaptopFacets() *laptopFacets {
+	return &laptopFacets{
+		brands:    newFoldedCounts(),
+		cpuBrands: newFoldedCounts(),
+		drivers:   make(map[string]uint32),
+		panels:    make(map[string]uint32),
+		ramSizes:  make([]uint32, len(ramRanges)),
+		prices:    make([]uint32, len(priceRanges)),
+	}
+}
+
+func (facets *laptopFacets) add(laptop *pb.Laptop) {
+	facets.total++
+
+	if brand := laptop.GetBrand(); brand != "" {
+		facets.brands.add(brand)
+	}
+	if brand := laptop.GetCpu().GetBrand(); brand != "" {
+		facets.cpuBrands.add(brand)
+	}
+	if laptop.GetScreen() != nil {
+		facets.panels[laptop.GetScreen().GetPanel().String()]++
+	}
+
+	drivers := make(map[pb.Storage_Driver]bool)
+	for _, storage := range laptop.GetStorages() {
+		drivers[storage.GetDriver()] = true
+	}
+	for driver := range drivers {
+		facets.drivers[driver.String()]++
+	}
+
+	facets.ramSizes[rangeIndex(ramRanges, float64(toBit(laptop.GetRam())))]++
+	facets.prices[rangeIndex(priceRanges, laptop.GetPriceUsd())]++
+}
+
+func (facets *laptopFacets) response() *pb.FacetLaptopsResponse {
+	return &pb.FacetLaptopsResponse{
+		Total:          facets.total,
+		Brands:         valueCounts(facets.brands.counts()),
+		CpuBrands:      valueCounts(facets.cpuBrands.counts()),
+		RamSizes:       rangeCounts(ramRanges, facets.ramSizes),
+		StorageDrivers: valueCounts(facets.drivers),
+		ScreenPanels:   valueCounts(facets.panels),
+		PriceRanges:    rangeCounts(priceRanges, facets.prices),
+	}
+}
+
+// foldedCounts counts the values regardless of their case, like the filters match them with strings.EqualFold.
+// The values differing only by their case are counted under the smallest of their spellings
+type foldedCounts struct {
+	byKey    map[string]uint32 // folded value -> count
+	spelling map[string]string // folded value -> spelling
+}
+
+func newFoldedCounts() *foldedCounts {
+	return &foldedCounts{
+		byKey:    make(map[string]uint32),
+		spelling: make(map[string]string),
+	}
+}
+
+func (folded *foldedCounts) add(value string) {
+	key := foldCase(value)
+	folded.byKey[key]++
+	if spelling, found := folded.spelling[key]; !found || value < spelling {
+		folded.spelling[key] = value
+	}
+}
+
+// counts returns the counts by the spelling of the values
+func (folded *foldedCounts) counts() map[string]uint32 {
+	result := make(map[string]uint32, len(folded.byKey))
+	for key, count := range folded.byKey {
+		result[folded.spelling[key]] = count
+	}
+	return result
+}
+
+// foldCase replaces every rune by the smallest rune strings.EqualFold takes as equal to it
+func foldCase(value string) string {
+	return strings.Map(func(r rune) rune {
+		smallest := r
+		for folded := unicode.SimpleFold(r); folded != r; folded = unicode.SimpleFold(folded) {
+			smallest = min(smallest, folded)
+		}
+		return smallest
+	}, value)
+}
+
+// rangeIndex returns the index of the range holding the value, values below the first range go in it
+func rangeIndex(ranges []facetRange, value float64) int {
+	i := len(ranges) - 1
+	for i > 0 && value < ranges[i].min {
+		i--
+	}
+	return i
+}
+
+// valueCounts orders the counts from the largest to the smallest, then by value
+func valueCounts(counts map[string]uint32) []*pb.FacetCount {
+	result := make([]*pb.FacetCount, 0, len(counts))
+	for value, count := range counts {
+		result = append(result, &pb.FacetCount{Value: value, Count: count})
+	}
+
+	slices.SortFunc(result, func(a, b *pb.FacetCount) int {
+		if order := cmp.Compare(b.Count, a.Count); order != 0 {
+			return order
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	return result
+}
+
+// rangeCounts returns the count of every range, even the empty ones so the buckets are always the same
+func rangeCounts(ranges []facetRange, counts []uint32) []*pb.FacetCount {
+	result := make([]*pb.FacetCount, len(ranges))
+	for i, bucket := range ranges {
+		result[i] = &pb.FacetCount{Value: bucket.label, Count: counts[i]}
+	}
+	return result
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/moataz-hamed/pb/pb"
+)
+
+// laptopIndex keeps the IDs of the stored laptops sorted by one of their values,
+// so the laptops having this value in a range are found without a full scan
+type laptopIndex struct {
+	value   func(laptop *pb.Laptop) float64
+	entries []indexEntry // in the order of compareEntries, without the NaN values
+	nan     []indexEntry // NaN can't be ordered, and like a scan every range accepts it
+}
+
+type indexEntry struct {
+	value float64
+	id    string
+}
+
+func newLaptopIndex(value func(laptop *pb.Laptop) float64) *laptopIndex {
+	return &laptopIndex{value: value}
+}
+
+func compareEntries(a, b indexEntry) int {
+	if result := cmp.Compare(a.value, b.value); result != 0 {
+		return result
+	}
+	return cmp.Compare(a.id, b.id)
+}
+
+// bucket returns the entries the value belongs to
+func (index *laptopIndex) bucket(value float64) *[]indexEntry {
+	if math.IsNaN(value) {
+		return &index.nan
+	}
+	return &index.entries
+}
+
+func (index *laptopIndex) insert(laptop *pb.Laptop) {
+	entry := indexEntry{value: index.value(laptop), id: laptop.GetId()}
+	entries := index.bucket(entry.value)
+	i, _ := slices.BinarySearchFunc(*entries, entry, compareEntries)
+	*entries = slices.Insert(*entries, i, entry)
+}
+
+// remove drops the entry of the laptop, which must be the version that was inserted
+func (index *laptopIndex) remove(laptop *pb.Laptop) {
+	entry := indexEntry{value: index.value(laptop), id: laptop.GetId()}
+	entries := index.bucket(entry.value)
+	i, found := slices.BinarySearchFunc(*entries, entry, compareEntries)
+	if found {
+		*entries = slices.Delete(*entries, i, i+1)
+	}
+}
+
+// lookup returns the entries whose value is between min and max, both included, and the ones whose value is NaN
+// since the filters never compare it out. The result must not be modified
+func (index *laptopIndex) lookup(min, max float64) []indexEntry {
+	from, _ := slices.BinarySearchFunc(index.entries, min, func(entry indexEntry, value float64) int {
+		if entry.value < value {
+			return -1
+		}
+		return 1
+	})
+	to, _ := slices.BinarySearchFunc(index.entries, max, func(entry indexEntry, value float64) int {
+		if entry.value <= value {
+			return -1
+		}
+		return 1
+	})
+	if from >= to {
+		return index.nan
+	}
+	if len(index.nan) == 0 {
+		return index.entries[from:to]
+	}
+	return append(slices.Clip(index.entries[from:to]), index.nan...)
+}
+
+// laptopIndexes are the secondary indexes of the in-memory laptop store, on the values the filter has bounds for
+type laptopIndexes struct {
+	price *laptopIndex
+	cores *laptopIndex
+	ghz   *laptopIndex
+	ram   *laptopIndex
+}
+
+func newLaptopIndexes() *laptopIndexes {
+	return &laptopIndexes{
+		price: newLaptopIndex(func(laptop *pb.Laptop) float64 { return laptop.GetPriceUsd() }),
+		cores: newLaptopIndex(func(laptop *pb.Laptop) float64 { return float64(laptop.GetCpu().GetNumberCores()) }),
+		ghz:   newLaptopIndex(func(laptop *pb.Laptop) float64 { return laptop.GetCpu().GetMinGhz() }),
+		ram:   newLaptopIndex(func(laptop *pb.Laptop) float64 { return float64(toBit(laptop.GetRam())) }),
+	}
+}
+
+func (indexes *laptopIndexes) all() []*laptopIndex {
+	return []*laptopIndex{indexes.price, indexes.cores, indexes.ghz, indexes.ram}
+}
+
+// update replaces the entries of the old version of a laptop by the updated one, either of them can be nil
+func (indexes *laptopIndexes) update(old *pb.Laptop, updated *pb.Laptop) {
+	for _, index := range indexes.all() {
+		if old != nil {
+			index.remove(old)
+		}
+		if updated != nil {
+			index.insert(updated)
+		}
+	}
+}
+
+// plan returns the IDs of the laptops the filter may accept, in ascending order, using the index with the
+// narrowest range. It returns false when the filter is not selective enough for an index to beat a full scan
+func (indexes *laptopIndexes) plan(filter *pb.Filter, total int) ([]string, bool) {
+	if filter == nil {
+		return nil, false
+	}
+
+	maxPrice := math.Inf(1)
+	if filter.GetMaxPriceUsd() > 0 {
+		maxPrice = filter.GetMaxPriceUsd()
+	}
+
+	candidates := [][]indexEntry{
+		indexes.price.lookup(math.Inf(-1), maxPrice),
+		indexes.cores.lookup(float64(filter.GetMinCpuCores()), math.Inf(1)),
+		indexes.ghz.lookup(filter.GetMinCpuGhz(), math.Inf(1)),
+		indexes.ram.lookup(float64(toBit(filter.GetMinRam())), math.Inf(1)),
+	}
+
+	best := slices.MinFunc(candidates, func(a, b []indexEntry) int {
+		return cmp.Compare(len(a), len(b))
+	})
+
+	// sorting the candidates by ID costs more than scanning when they are a large part of the store
+	if len(best)*4 > total {
+		return nil, false
+	}
+
+	ids := make([]string, len(best))
+	for i, entry := range best {
+		ids[i] = entry.id
+	}
+	slices.Sort(ids)
+	return ids, true
+}
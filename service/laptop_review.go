@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/moataz-hamed/pb/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxReviewTitleLength = 200
+	maxReviewBodyLength  = 10000
+)
+
+const (
+	defaultReviewPageSize = 20
+	maxReviewPageSize     = 100
+)
+
+// reviewOrder sorts the cursors of the reviews holding their creation time, the newest first
+var reviewOrder = []*pb.SortKey{{Descending: true}}
+
+// WithReviewStore sets the store of the reviews, they are only kept in memory by default
+func WithReviewStore(reviewStore ReviewStore) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.reviewStore = reviewStore
+	}
+}
+
+func reviewCursor(review *pb.Review) searchCursor {
+	// microseconds since 1970 are exact as a float64, the creation times are truncated to them
+	return searchCursor{Values: []float64{float64(review.GetCreatedAt().AsTime().UnixMicro())}, ID: review.GetId()}
+}
+
+// SubmitReview saves the review of the authenticated user and sets their rating of the laptop to its score
+func (server *LaptopServer) SubmitReview(ctx context.Context, in *pb.SubmitReviewRequest) (*pb.SubmitReviewResponse, error) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	laptopID := in.GetLaptopId()
+	log.Printf("receive a submit-review request from %s for laptop %s", claims.Username, laptopID)
+
+	if utf8.RuneCountInString(in.GetTitle()) > maxReviewTitleLength {
+		return nil, status.Errorf(codes.InvalidArgument, "Title is too long, Max title length is:%d", maxReviewTitleLength)
+	}
+	if in.GetBody() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "review body is not provided")
+	}
+	if utf8.RuneCountInString(in.GetBody()) > maxReviewBodyLength {
+		return nil, status.Errorf(codes.InvalidArgument, "Body is too long, Max body length is:%d", maxReviewBodyLength)
+	}
+
+	err := server.ratingScale.check(in.GetScore())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "score is invalid: %v", err)
+	}
+
+	laptop, err := server.LaptopStore.Find(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Can not find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", laptopID)
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Can not generate a new review ID: %v", err)
+	}
+
+	review := &pb.Review{
+		Id:        id.String(),
+		LaptopId:  laptopID,
+		Username:  claims.Username,
+		Title:     in.GetTitle(),
+		Body:      in.GetBody(),
+		Score:     in.GetScore(),
+		Status:    pb.Review_PENDING,
+		CreatedAt: timestamppb.New(time.Now().Truncate(time.Microsecond)),
+	}
+
+	// the review is saved before its score is rated, so a failure never leaves a rating without its review.
+	// A score which is not rated is rated again once the user submits their review again
+	replaced, err := server.reviewStore.Save(review)
+	if err != nil {
+		return nil, status.Errorf(storeErrorCode(err), "Can not save review to the store:%v", err)
+	}
+
+	_, _, err = server.ratingStore.Rate(laptopID, claims.Username, in.GetScore())
+	if err != nil {
+		return nil, status.Errorf(storeErrorCode(err), "can't add rate to the laptop: %v", err)
+	}
+
+	err = server.leaderboard.refresh(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "can't rank the laptop: %v", err)
+	}
+
+	log.Printf("Saved review with id: %s", review.Id)
+	return &pb.SubmitReviewResponse{Review: review, Replaced: replaced}, nil
+}
+
+// ListLaptopReviews returns one page of the reviews of the laptop which are not hidden
+func (server *LaptopServer) ListLaptopReviews(ctx context.Context, in *pb.ListLaptopReviewsRequest) (*pb.ListLaptopReviewsResponse, error) {
+	laptopID := in.GetLaptopId()
+	log.Printf("receive a list-laptop-reviews request for laptop %s", laptopID)
+
+	pageSize := int(in.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultReviewPageSize
+	}
+	if pageSize > maxReviewPageSize {
+		pageSize = maxReviewPageSize
+	}
+
+	laptop, err := server.LaptopStore.Find(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Can not find laptop: %v", err)
+	}
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", laptopID)
+	}
+
+	digest, err := queryDigest(&pb.ListLaptopReviewsRequest{LaptopId: laptopID})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unexpected error,%v", err)
+	}
+
+	var after *searchCursor
+	if in.GetPageToken() != "" {
+		token, err := server.pageTokens.decode(in.GetPageToken(), digest)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "page token is invalid: %v", err)
+		}
+		after = &token.After
+	}
+
+	res := &pb.ListLaptopReviewsResponse{}
+	err = server.reviewStore.List(laptopID, func(review *pb.Review) error {
+		cursor := reviewCursor(review)
+		if review.GetStatus() == pb.Review_HIDDEN || (after != nil && compareCursors(cursor, *after, reviewOrder) <= 0) {
+			return nil
+		}
+
+		if len(res.Reviews) == pageSize {
+			next, err := server.pageTokens.encode(&pageToken{After: reviewCursor(res.Reviews[pageSize-1]), Query: digest})
+			if err != nil {
+				return err
+			}
+			res.NextPageToken = next
+			return errPageFull
+		}
+
+		res.Reviews = append(res.Reviews, review)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		return nil, status.Errorf(codes.Internal, "unexpected error,%v", err)
+	}
+
+	return res, nil
+}
+
+// ModerateReview approves or hides a review
+func (server *LaptopServer) ModerateReview(ctx context.Context, in *pb.ModerateReviewRequest) (*pb.ModerateReviewResponse, error) {
+	reviewID := in.GetReviewId()
+	log.Printf("receive a moderate-review request for review %s with status %v", reviewID, in.GetStatus())
+
+	if in.GetStatus() != pb.Review_APPROVED && in.GetStatus() != pb.Review_HIDDEN {
+		return nil, status.Errorf(codes.InvalidArgument, "status must be APPROVED or HIDDEN")
+	}
+
+	review, err := server.reviewStore.SetStatus(reviewID, in.GetStatus())
+	if err != nil {
+		return nil, status.Errorf(storeErrorCode(err), "Can not moderate review:%v", err)
+	}
+
+	return &pb.ModerateReviewResponse{Review: review}, nil
+}
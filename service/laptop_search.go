@@ -0,0 +1,224 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+
+	"github.com/moataz-hamed/pb/pb"
+	"github.com/moataz-hamed/query"
+)
+
+// searchCursor is the position of a laptop in the order of a search: the values of its sort keys, then its ID
+type searchCursor struct {
+	Values []float64 `json:"values,omitempty"`
+	ID     string    `json:"id"`
+}
+
+// compareCursors orders the cursors by each sort key in its direction, ties are broken by ascending ID
+func compareCursors(a, b searchCursor, sortBy []*pb.SortKey) int {
+	for i, key := range sortBy {
+		result := cmp.Compare(a.Values[i], b.Values[i])
+		if key.GetDescending() {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// compileQuery returns the function matching the laptops with the query, it matches every laptop if the query is empty
+func compileQuery(input string) (func(laptop *pb.Laptop) bool, error) {
+	if input == "" {
+		return func(laptop *pb.Laptop) bool { return true }, nil
+	}
+
+	compiled, err := query.Compile(input, (&pb.Laptop{}).ProtoReflect().Descriptor())
+	if err != nil {
+		return nil, err
+	}
+	return func(laptop *pb.Laptop) bool { return compiled.Match(laptop) }, nil
+}
+
+func validateSortKeys(sortBy []*pb.SortKey) error {
+	for _, key := range sortBy {
+		if key.GetField() == pb.SortKey_UNKNOWN {
+			return fmt.Errorf("sort key field is not provided")
+		}
+		if _, ok := pb.SortKey_Field_name[int32(key.GetField())]; !ok {
+			return fmt.Errorf("unknown sort key field %d", key.GetField())
+		}
+	}
+	return nil
+}
+
+// sortValues returns the values of the sort keys for the laptop. The cursors only hold the values of the last laptop
+// of a page, so a laptop whose value moves past the cursor between two pages, like an average rating changing as
+// users rate, is skipped or sent again
+func (server *LaptopServer) sortValues(laptop *pb.Laptop, sortBy []*pb.SortKey) ([]float64, error) {
+	values := make([]float64, len(sortBy))
+	for i, key := range sortBy {
+		switch key.GetField() {
+		case pb.SortKey_PRICE_USD:
+			values[i] = laptop.GetPriceUsd()
+		case pb.SortKey_RELEASE_YEAR:
+			values[i] = float64(laptop.GetReleaseYear())
+		case pb.SortKey_CPU_MAX_GHZ:
+			values[i] = laptop.GetCpu().GetMaxGhz()
+		case pb.SortKey_RAM:
+			values[i] = float64(toBit(laptop.GetRam()))
+		case pb.SortKey_AVERAGE_RATING:
+			rating, err := server.ratingStore.Find(laptop.GetId())
+			if err != nil {
+				return nil, fmt.Errorf("cannot find rating:%w", err)
+			}
+			// laptops which are not rated yet come last in both directions
+			values[i] = math.NaN()
+			if rating != nil && rating.Count > 0 {
+				values[i] = rating.Mean()
+			}
+		}
+		values[i] = finiteSortValue(values[i], key.GetDescending())
+	}
+	return values, nil
+}
+
+// finiteSortValue replaces the values the page tokens can't hold: the infinities become the largest finite values
+// and NaN, which can't be ordered, comes last in both directions
+func finiteSortValue(value float64, descending bool) float64 {
+	switch {
+	case math.IsInf(value, 1):
+		return math.MaxFloat64
+	case math.IsInf(value, -1):
+		return -math.MaxFloat64
+	case !math.IsNaN(value):
+		return value
+	case descending:
+		return -math.MaxFloat64
+	default:
+		return math.MaxFloat64
+	}
+}
+
+// relevanceOrder sorts the cursors holding the relevance score of a text search, the best laptops first. The scores
+// depend on every stored laptop, so like the average ratings they may change between two pages
+var relevanceOrder = []*pb.SortKey{{Descending: true}}
+
+// searchStore calls found with the laptops matching the filter, and the text if it is not empty
+func (server *LaptopServer) searchStore(filter *pb.Filter, text string, found func(laptop *pb.Laptop) error) error {
+	if text == "" {
+		return server.LaptopStore.Search(filter, found)
+	}
+	return server.LaptopStore.SearchText(text, filter, func(laptop *pb.Laptop, score float64) error {
+		return found(laptop)
+	})
+}
+
+// sortedSearch collects every laptop matching the filter, the text and the match function which comes after
+// the cursor and sorts them
+func (server *LaptopServer) sortedSearch(filter *pb.Filter, text string, match func(laptop *pb.Laptop) bool, sortBy []*pb.SortKey, after *searchCursor) ([]*pb.Laptop, []searchCursor, error) {
+	var laptops []*pb.Laptop
+	var cursors []searchCursor
+
+	err := server.searchStore(filter, text, func(laptop *pb.Laptop) error {
+		if !match(laptop) {
+			return nil
+		}
+
+		values, err := server.sortValues(laptop, sortBy)
+		if err != nil {
+			return err
+		}
+
+		cursor := searchCursor{Values: values, ID: laptop.GetId()}
+		if after != nil && compareCursors(cursor, *after, sortBy) <= 0 {
+			return nil
+		}
+
+		laptops = append(laptops, laptop)
+		cursors = append(cursors, cursor)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	order := make([]int, len(laptops))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return compareCursors(cursors[a], cursors[b], sortBy)
+	})
+
+	sortedLaptops := make([]*pb.Laptop, len(order))
+	sortedCursors := make([]searchCursor, len(order))
+	for i, j := range order {
+		sortedLaptops[i] = laptops[j]
+		sortedCursors[i] = cursors[j]
+	}
+	return sortedLaptops, sortedCursors, nil
+}
+
+// searchPage sends the laptops of one page of a search, the last laptop added is held back
+// until the next one shows whether the page has to end with a token
+type searchPage struct {
+	stream        pb.LaptopService_SearchLaptopServer
+	size          int
+	sent          int
+	pending       *pb.Laptop
+	pendingCursor searchCursor
+	tokens        pageTokenCodec
+	query         []byte
+}
+
+// add returns errPageFull once the page is sent and no more laptops are needed
+func (page *searchPage) add(laptop *pb.Laptop, cursor searchCursor) error {
+	if page.pending != nil {
+		if page.sent+1 == page.size {
+			next, err := page.tokens.encode(&pageToken{After: page.pendingCursor, Query: page.query})
+			if err != nil {
+				return err
+			}
+			err = page.send(page.pending, next)
+			if err != nil {
+				return err
+			}
+			return errPageFull
+		}
+
+		err := page.send(page.pending, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	page.pending = laptop
+	page.pendingCursor = cursor
+	return nil
+}
+
+// flush sends the laptop held back once there are no more laptops
+func (page *searchPage) flush() error {
+	if page.pending == nil {
+		return nil
+	}
+	return page.send(page.pending, "")
+}
+
+func (page *searchPage) send(laptop *pb.Laptop, nextPageToken string) error {
+	res := &pb.SearchLaptopResponse{Laptop: laptop, NextPageToken: nextPageToken}
+
+	err := page.stream.Send(res)
+	if err != nil {
+		return err
+	}
+	log.Printf("Send laptop with id:%s", laptop.GetId())
+	page.sent++
+	page.pending = nil
+	return nil
+}
@@ -1,11 +1,13 @@
 package service
 
 import (
-	"bytes"
 	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/moataz-hamed/pb/pb"
@@ -14,14 +16,86 @@ import (
 )
 
 type LaptopServer struct {
-	LaptopStore LaptopStore
-	ImageStore  ImageStore
-	ratingStore RatingStore
+	LaptopStore  LaptopStore
+	ImageStore   ImageStore
+	ratingStore  RatingStore
+	ratingScale  RatingScale
+	reviewStore  ReviewStore
+	minVotes     uint32
+	maxImageSize int64
+	uploadStore  UploadStore
+	// content types of the images which can be uploaded
+	imageContentTypes map[string]bool
+	leaderboard       *leaderboard
+	pageTokens        pageTokenCodec
 	pb.UnimplementedLaptopServiceServer
 }
 
-// 1 MegaByte max sizes
-const maxImageSize = 1 << 20
+// LaptopServerOption changes the default configuration of a LaptopServer
+type LaptopServerOption func(server *LaptopServer)
+
+// WithPageTokenKey sets the key signing the search page tokens, by default a random key is used
+// so the tokens can't be used anymore once the server restarts
+func WithPageTokenKey(key []byte) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.pageTokens = pageTokenCodec{key: key}
+	}
+}
+
+// WithRatingScale sets the scores users can give, DefaultRatingScale is used otherwise
+func WithRatingScale(scale RatingScale) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.ratingScale = scale
+	}
+}
+
+// WithMaxImageSize sets the maximum size in bytes of the uploaded images, 1 MegaByte by default
+func WithMaxImageSize(size int64) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.maxImageSize = size
+	}
+}
+
+// WithImageContentTypes sets the content types of the images which can be uploaded, the images
+// are sniffed to find out their real type. JPEG, PNG, GIF and WebP images are allowed by default
+func WithImageContentTypes(contentTypes ...string) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.imageContentTypes = make(map[string]bool)
+		for _, contentType := range contentTypes {
+			server.imageContentTypes[contentType] = true
+		}
+	}
+}
+
+// WithMinVotes sets the number of votes a laptop needs before its own average outweighs the prior
+// in the ranking of ListTopRatedLaptops
+func WithMinVotes(votes uint32) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.minVotes = votes
+	}
+}
+
+// size of the chunks of the downloaded images
+const imageChunkSize = 64 << 10
+
+// maximum number of laptops created by one BatchCreateLaptops call
+const maxBatchCreateSize = 10000
+
+const (
+	defaultTopRatedLimit = 10
+	maxTopRatedLimit     = 100
+)
+
+// maximum number of laptops in one GetLaptopRatingStats call
+const maxRatingStatsLaptops = 1000
+
+const (
+	defaultSearchPageSize = 100
+	maxSearchPageSize     = 1000
+)
+
+// errPageFull stops a search once a page is sent
+var errPageFull = errors.New("page is full")
 
 // mustEmbedUnimplementedLaptopServiceServer implements pb.LaptopServiceServer.
 func (*LaptopServer) mustEmbedUnimplementedLaptopServiceServer() {
@@ -29,6 +103,11 @@ func (*LaptopServer) mustEmbedUnimplementedLaptopServiceServer() {
 }
 
 func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	claims, ok := ClaimsFromContext(stream.Context())
+	if !ok {
+		return logError(status.Errorf(codes.Unauthenticated, "user is not authenticated"))
+	}
+
 	for {
 		err := contextError(stream.Context())
 		if err != nil {
@@ -44,35 +123,186 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 			return logError(status.Errorf(codes.Unknown, "Can't receive stream request:%v", err))
 		}
 
-		laptopID := req.GetLaptopId()
-		score := req.GetScore()
+		log.Printf("received a rate-laptop request from %s: ID:%v,score=%.2f,retract=%v", claims.Username, req.GetLaptopId(), req.GetScore(), req.GetRetract())
 
-		log.Printf("received a rate-laptop request: ID:%v,score=%.2f", laptopID, score)
+		res, err := server.rateLaptop(claims.Username, req)
+		if err != nil {
+			return logError(err)
+		}
 
-		found, err := server.LaptopStore.Find(laptopID)
+		err = stream.Send(res)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "can't find laptop", err))
+			return logError(status.Errorf(codes.Unknown, "Can't send response to the client: %v", err))
 		}
+	}
+	return nil
+}
+
+// rateLaptop handles one request of a RateLaptop stream. A request which fails on its own, for a bad score or
+// a laptop or score which does not exist, is answered with an error code and the next ones are still rated.
+// The returned error is only set when the stream can't go on
+func (server *LaptopServer) rateLaptop(username string, req *pb.RateLaptopRequest) (*pb.RateLaptopResponse, error) {
+	laptopID := req.GetLaptopId()
+	score := req.GetScore()
 
-		if found == nil {
-			return logError(status.Errorf(codes.NotFound, "laptop is not found in our database %v", laptopID))
+	if !req.GetRetract() {
+		err := server.ratingScale.check(score)
+		if err != nil {
+			return rateLaptopError(laptopID, codes.InvalidArgument, "score is invalid: %v", err), nil
 		}
+	}
+
+	found, err := server.LaptopStore.Find(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "can't find laptop: %v", err)
+	}
+	if found == nil {
+		return rateLaptopError(laptopID, codes.NotFound, "laptop is not found in our database %v", laptopID), nil
+	}
 
-		rating, err := server.ratingStore.Add(laptopID, score)
+	var rating *Rating
+	result := pb.RateLaptopResponse_RETRACTED
+	if req.GetRetract() {
+		rating, err = server.ratingStore.Retract(laptopID, username)
+		if errors.Is(err, ErrNotFound) {
+			return rateLaptopError(laptopID, codes.NotFound, "laptop %v has not been rated by %s", laptopID, username), nil
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "can't retract the rate of the laptop: %v", err)
+		}
+	} else {
+		var created bool
+		rating, created, err = server.ratingStore.Rate(laptopID, username, score)
+		if errors.Is(err, ErrNotFound) {
+			// the laptop was deleted since it was found
+			return rateLaptopError(laptopID, codes.NotFound, "laptop is not found in our database %v", laptopID), nil
+		}
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "can't add rate to the laptop", err))
+			return nil, status.Errorf(codes.Internal, "can't add rate to the laptop: %v", err)
 		}
 
-		res := &pb.RateLaptopResponse{
-			LaptopId:     laptopID,
-			RatedCount:   rating.Count,
-			AverageScore: rating.Sum / float64(rating.Count),
+		result = pb.RateLaptopResponse_REPLACED
+		if created {
+			result = pb.RateLaptopResponse_CREATED
+		}
+	}
+
+	err = server.leaderboard.refresh(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "can't rank the laptop: %v", err)
+	}
+
+	return &pb.RateLaptopResponse{
+		LaptopId:     laptopID,
+		RatedCount:   rating.Count,
+		AverageScore: rating.Mean(),
+		Result:       result,
+	}, nil
+}
+
+// rateLaptopError is the response to a request of a RateLaptop stream which failed on its own
+func rateLaptopError(laptopID string, code codes.Code, format string, a ...any) *pb.RateLaptopResponse {
+	message := fmt.Sprintf(format, a...)
+	log.Print(message)
+	return &pb.RateLaptopResponse{
+		LaptopId:     laptopID,
+		ErrorCode:    int32(code),
+		ErrorMessage: message,
+	}
+}
+
+// GetLaptopRatingStats returns the stats of the scores given to each laptop, a laptop nobody rated has a zero count
+func (server *LaptopServer) GetLaptopRatingStats(ctx context.Context, in *pb.GetLaptopRatingStatsRequest) (*pb.GetLaptopRatingStatsResponse, error) {
+	laptopIDs := in.GetLaptopIds()
+	log.Printf("receive a get-laptop-rating-stats request for %d laptops", len(laptopIDs))
+
+	if len(laptopIDs) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "laptop IDs are not provided")
+	}
+	if len(laptopIDs) > maxRatingStatsLaptops {
+		return nil, status.Errorf(codes.InvalidArgument, "Too many laptops, Max laptops is:%d", maxRatingStatsLaptops)
+	}
+
+	res := &pb.GetLaptopRatingStatsResponse{}
+	for _, laptopID := range laptopIDs {
+		laptop, err := server.LaptopStore.Find(laptopID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Can not find laptop: %v", err)
+		}
+		if laptop == nil {
+			return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", laptopID)
 		}
 
+		rating, err := server.ratingStore.Find(laptopID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Can not find rating: %v", err)
+		}
+		if rating == nil {
+			rating = &Rating{}
+		}
+
+		stats := &pb.LaptopRatingStats{
+			LaptopId:          laptopID,
+			Count:             rating.Count,
+			Mean:              rating.Mean(),
+			Median:            rating.Median(),
+			StandardDeviation: rating.StandardDeviation(),
+		}
+		for _, bucket := range rating.Histogram {
+			stats.Histogram = append(stats.Histogram, &pb.RatingBucket{Score: bucket.Score, Count: bucket.Count})
+		}
+		res.Stats = append(res.Stats, stats)
+	}
+
+	return res, nil
+}
+
+// ListTopRatedLaptops streams the best rated laptops matching the filter
+func (server *LaptopServer) ListTopRatedLaptops(in *pb.ListTopRatedLaptopsRequest, stream pb.LaptopService_ListTopRatedLaptopsServer) error {
+	filter := in.GetFilter()
+	log.Printf("receive a list-top-rated-laptops request with filter:%v, limit:%d and min votes:%d", filter, in.GetLimit(), in.GetMinVotes())
+
+	limit := int(in.GetLimit())
+	if limit == 0 {
+		limit = defaultTopRatedLimit
+	}
+	if limit > maxTopRatedLimit {
+		limit = maxTopRatedLimit
+	}
+
+	sent := 0
+	err := server.leaderboard.top(func(entry leaderboardEntry) error {
+		if sent == limit {
+			return errPageFull
+		}
+		if entry.rating.Count < in.GetMinVotes() {
+			return nil
+		}
+
+		laptop, err := server.LaptopStore.Find(entry.laptopID)
+		if err != nil {
+			return err
+		}
+		// a laptop deleted since the board was read
+		if laptop == nil || !isQualified(filter, laptop) {
+			return nil
+		}
+
+		res := &pb.ListTopRatedLaptopsResponse{
+			Laptop:        laptop,
+			BayesianScore: entry.score,
+			RatedCount:    entry.rating.Count,
+			AverageScore:  entry.rating.Mean(),
+		}
 		err = stream.Send(res)
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "Can't send response to the client", err))
+			return err
 		}
+		sent++
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		return status.Errorf(codes.Internal, "unexpected error,%v", err)
 	}
 	return nil
 }
@@ -97,126 +327,655 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		return logError(status.Errorf(codes.InvalidArgument, "laptop %s does not exist", laptopID))
 	}
 
-	imageData := bytes.Buffer{}
-	imageSize := 0
+	// the chunks go straight to the image store, which drops the image if the reader fails
+	chunks := &imageChunkReader{stream: stream, maxSize: server.maxImageSize}
+	imageData := server.newImageVerifier(chunks, imageType, req.GetInto().GetSha256())
+	imageID, deduplicated, err := server.ImageStore.Save(laptopID, imageType, imageData)
+	if chunks.err != nil {
+		return chunks.err
+	}
+	if imageData.err != nil {
+		return imageData.err
+	}
+	if err != nil {
+		return logError(status.Errorf(codes.Internal, "can't save image to the store: %v", err))
+	}
 
-	for {
+	res := &pb.UploadImageResponse{
+		Id:           imageID,
+		Size:         uint64(chunks.size),
+		Deduplicated: deduplicated,
+	}
+
+	err = stream.SendAndClose(res)
+	if err != nil {
+		return logError(status.Errorf(codes.Unknown, "Can't send response %v", err))
+	}
+
+	log.Printf("saved image with id:%v and size: %d", imageID, chunks.size)
+	return nil
+}
 
-		if err := contextError(stream.Context()); err != nil {
+// DownloadImage sends the info of the image and then its data in chunks
+func (server *LaptopServer) DownloadImage(in *pb.DownloadImageRequest, stream pb.LaptopService_DownloadImageServer) error {
+	imageID := in.GetImageId()
+	log.Printf("receive a download image request for image %s and variant %d", imageID, in.GetVariant())
+
+	info, data, err := server.ImageStore.Open(imageID, in.GetVariant())
+	if errors.Is(err, ErrNotFound) {
+		return logError(status.Errorf(codes.NotFound, "image %s does not exist", imageID))
+	}
+	if errors.Is(err, ErrUnknownVariant) {
+		return logError(status.Errorf(codes.InvalidArgument, "image variant %d is not configured", in.GetVariant()))
+	}
+	if errors.Is(err, ErrNoVariants) || errors.Is(err, ErrTooManyPixels) {
+		return logError(status.Errorf(codes.FailedPrecondition, "image %s has no variants: %v", imageID, err))
+	}
+	if err != nil {
+		return logError(status.Errorf(codes.Internal, "can't open image: %v", err))
+	}
+	defer data.Close()
+
+	res := &pb.DownloadImageResponse{
+		Data: &pb.DownloadImageResponse_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:   info.LaptopID,
+				ImageTypes: info.Type,
+				Size:       uint64(info.Size),
+				Sha256:     info.SHA256,
+			},
+		},
+	}
+	err = stream.Send(res)
+	if err != nil {
+		return logError(status.Errorf(codes.Unknown, "Can't send image info %v", err))
+	}
+
+	buffer := make([]byte, imageChunkSize)
+	for {
+		err := contextError(stream.Context())
+		if err != nil {
 			return err
 		}
 
-		log.Print("Waiting to receive more data")
-
-		req, err := stream.Recv()
+		n, err := data.Read(buffer)
+		if n > 0 {
+			res := &pb.DownloadImageResponse{
+				Data: &pb.DownloadImageResponse_ChunkData{ChunkData: buffer[:n]},
+			}
+			err := stream.Send(res)
+			if err != nil {
+				return logError(status.Errorf(codes.Unknown, "Can't send chunk data %v", err))
+			}
+		}
 		if err == io.EOF {
-			log.Print("No more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "can't receive chunk data %v", err))
+			return logError(status.Errorf(codes.Internal, "can't read image data %v", err))
 		}
+	}
+
+	log.Printf("sent image with id:%v", imageID)
+	return nil
+}
+
+// DeleteImage removes the image, the data it shares with other images is kept for them
+func (server *LaptopServer) DeleteImage(ctx context.Context, in *pb.DeleteImageRequest) (*pb.DeleteImageResponse, error) {
+	imageID := in.GetImageId()
+	log.Printf("receive a delete image request for image %s", imageID)
 
-		chunk := req.GetChunkData()
-		size := len(chunk)
-		imageSize += size
+	err := server.ImageStore.Delete(imageID)
+	if err != nil {
+		return nil, logError(status.Errorf(storeErrorCode(err), "can't delete image: %v", err))
+	}
+
+	log.Printf("deleted image with id:%v", imageID)
+	return &pb.DeleteImageResponse{}, nil
+}
+
+func logError(err error) error {
+	if err != nil {
+		log.Print(err)
+	}
+	return err
+}
 
-		if imageSize > maxImageSize {
-			return logError(status.Errorf(codes.InvalidArgument, "Image is too large, Max image size is:%d", maxImageSize))
+// NewLaptopServer serves the laptops of the store, the ratings are only kept in memory if ratingStore is nil.
+// The server watches the store until it is closed
+func NewLaptopServer(store LaptopStore, imageStore ImageStore, ratingStore RatingStore, options ...LaptopServerOption) (*LaptopServer, error) {
+	if ratingStore == nil {
+		ratingStore = NewInMemoryRatingStore()
+	}
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate page token key:%w", err)
+	}
+
+	server := &LaptopServer{
+		LaptopStore:       store,
+		ImageStore:        imageStore,
+		ratingStore:       ratingStore,
+		ratingScale:       DefaultRatingScale,
+		reviewStore:       NewInMemoryReviewStore(),
+		minVotes:          defaultMinVotes,
+		maxImageSize:      defaultMaxImageSize,
+		imageContentTypes: make(map[string]bool),
+		pageTokens:        pageTokenCodec{key: key},
+	}
+	for _, contentType := range defaultImageContentTypes {
+		server.imageContentTypes[contentType] = true
+	}
+	for _, option := range options {
+		option(server)
+	}
+
+	priorMean := (server.ratingScale.Min + server.ratingScale.Max) / 2
+	server.leaderboard, err = newLeaderboard(store, server.ratingStore, priorMean, server.minVotes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot rank the rated laptops:%w", err)
+	}
+	return server, nil
+}
+
+// Close stops watching the laptop store, the stores themselves are closed by their owner
+func (server *LaptopServer) Close() {
+	server.leaderboard.close()
+}
+
+func (server *LaptopServer) CreateLaptop(ctx context.Context, in *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
+	laptop := in.GetLaptop()
+	log.Println("Received Create Laptop Request with this id:", laptop.Id)
+	err := prepareLaptopID(laptop)
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.LaptopStore.Save(laptop)
+	if err != nil {
+		return nil, saveError(err)
+	}
+
+	log.Printf("Saved laptop with id: %s", laptop.Id)
+	return &pb.CreateLaptopResponse{Id: laptop.Id, Version: laptop.Version}, nil
+}
+
+// BatchCreateLaptops creates the laptops of the stream and returns the result of each one,
+// with the all_or_nothing option none of them is created if one fails
+func (server *LaptopServer) BatchCreateLaptops(stream pb.LaptopService_BatchCreateLaptopsServer) error {
+	var options *pb.BatchCreateOptions
+	var laptops []*pb.Laptop
+	var results []*pb.BatchCreateLaptopResult
+
+	for {
+		err := contextError(stream.Context())
+		if err != nil {
+			return err
 		}
 
-		_, err = imageData.Write(chunk)
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "can't write chunk data %v", err))
+			return logError(status.Errorf(codes.Unknown, "Can't receive stream request:%v", err))
+		}
+
+		if req.GetOptions() != nil {
+			if len(laptops) > 0 {
+				return logError(status.Errorf(codes.InvalidArgument, "options must be sent before the laptops"))
+			}
+			options = req.GetOptions()
+			continue
+		}
+
+		laptop := req.GetLaptop()
+		if laptop == nil {
+			return logError(status.Errorf(codes.InvalidArgument, "laptop is not provided"))
+		}
+		if len(laptops) == maxBatchCreateSize {
+			return logError(status.Errorf(codes.InvalidArgument, "Batch is too large, Max batch size is:%d", maxBatchCreateSize))
 		}
 
+		result := &pb.BatchCreateLaptopResult{}
+		setResultStatus(result, prepareLaptopID(laptop))
+		result.Id = laptop.Id
+
+		laptops = append(laptops, laptop)
+		results = append(results, result)
 	}
+	log.Printf("Received Batch Create Laptops Request with %d laptops, all or nothing:%v", len(laptops), options.GetAllOrNothing())
 
-	imageID, err := server.ImageStore.Save(laptopID, imageType, imageData)
-	if err != nil {
-		return logError(status.Errorf(codes.Internal, "can't save image to the store", err))
+	if options.GetAllOrNothing() {
+		server.saveAllLaptops(laptops, results)
+	} else {
+		for i, laptop := range laptops {
+			if codes.Code(results[i].Code) != codes.OK {
+				continue
+			}
+
+			err := server.LaptopStore.Save(laptop)
+			if err != nil {
+				setResultStatus(results[i], saveError(err))
+				continue
+			}
+			results[i].Version = laptop.Version
+		}
 	}
 
-	res := &pb.UploadImageResponse{
-		Id:   imageID,
-		Size: uint32(imageSize),
+	res := &pb.BatchCreateLaptopsResponse{Results: results}
+	for _, result := range results {
+		if codes.Code(result.Code) == codes.OK {
+			res.CreatedCount++
+		}
 	}
 
-	err = stream.SendAndClose(res)
+	err := stream.SendAndClose(res)
 	if err != nil {
 		return logError(status.Errorf(codes.Unknown, "Can't send response %v", err))
 	}
 
-	log.Printf("saved image with id:%v and size: %d", imageID, imageSize)
+	log.Printf("Created %d laptops out of %d", res.CreatedCount, len(laptops))
 	return nil
 }
 
-func logError(err error) error {
-	if err != nil {
-		log.Print(err)
+// saveAllLaptops saves the laptops in one change if none of them fails, otherwise the ones
+// which could be created are aborted
+func (server *LaptopServer) saveAllLaptops(laptops []*pb.Laptop, results []*pb.BatchCreateLaptopResult) {
+	failed := false
+	ids := make(map[string]bool, len(laptops))
+	for i, laptop := range laptops {
+		if codes.Code(results[i].Code) != codes.OK {
+			failed = true
+			continue
+		}
+
+		// the store checks the IDs again when saving, this only tells which laptops conflict
+		found, err := server.LaptopStore.Find(laptop.Id)
+		if err != nil {
+			setResultStatus(results[i], status.Errorf(codes.Internal, "Can not find laptop: %v", err))
+			failed = true
+			continue
+		}
+		if found != nil || ids[laptop.Id] {
+			setResultStatus(results[i], saveError(fmt.Errorf("laptop %s:%w", laptop.Id, ErrAlreadyExists)))
+			failed = true
+			continue
+		}
+		ids[laptop.Id] = true
 	}
-	return err
-}
 
-func NewLaptopServer(store LaptopStore, imageStore ImageStore, ratingStore RatingStore) *LaptopServer {
+	if !failed && len(laptops) > 0 {
+		err := server.LaptopStore.SaveAll(laptops)
+		if err == nil {
+			for i, laptop := range laptops {
+				results[i].Version = laptop.Version
+			}
+			return
+		}
 
-	return &LaptopServer{LaptopStore: store, ImageStore: imageStore, ratingStore: ratingStore}
+		for _, result := range results {
+			setResultStatus(result, saveError(err))
+		}
+		return
+	}
+
+	for _, result := range results {
+		if codes.Code(result.Code) == codes.OK {
+			setResultStatus(result, status.Errorf(codes.Aborted, "laptop is not created because another laptop of the batch failed"))
+		}
+	}
 }
 
-func (server *LaptopServer) CreateLaptop(ctx context.Context, in *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
-	laptop := in.GetLaptop()
-	log.Println("Received Create Laptop Request with this id:", laptop.Id)
+func setResultStatus(result *pb.BatchCreateLaptopResult, err error) {
+	st := status.Convert(err)
+	result.Code = int32(st.Code())
+	result.Message = st.Message()
+}
+
+// prepareLaptopID checks the ID of a laptop to create if it has one, or gives it a new one
+func prepareLaptopID(laptop *pb.Laptop) error {
 	if len(laptop.Id) > 0 {
 		// check if it is a valid uuid
 		_, err := uuid.Parse(laptop.Id)
 		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "laptop ID is invalid: %v", err)
+			return status.Errorf(codes.InvalidArgument, "laptop ID is invalid: %v", err)
 		}
-	} else {
-		id, err := uuid.NewRandom()
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "Can not generate a new laptop ID: %v", err)
+		return nil
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Can not generate a new laptop ID: %v", err)
+	}
+	laptop.Id = id.String()
+	return nil
+}
+
+func saveError(err error) error {
+	code := codes.Internal
+	if errors.Is(err, ErrAlreadyExists) {
+		code = codes.AlreadyExists
+	}
+	return status.Errorf(code, "Can not save laptop to the store:%v", err)
+}
+
+func (server *LaptopServer) GetLaptop(ctx context.Context, in *pb.GetLaptopRequest) (*pb.GetLaptopResponse, error) {
+	laptopID := in.GetId()
+	log.Println("Received Get Laptop Request with this id:", laptopID)
+
+	laptop, err := server.LaptopStore.Find(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Can not find laptop: %v", err)
+	}
+
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s does not exist", laptopID)
+	}
+
+	return &pb.GetLaptopResponse{Laptop: laptop}, nil
+}
+
+func (server *LaptopServer) UpdateLaptop(ctx context.Context, in *pb.UpdateLaptopRequest) (*pb.UpdateLaptopResponse, error) {
+	laptop := in.GetLaptop()
+	if laptop == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "laptop is not provided")
+	}
+	log.Println("Received Update Laptop Request with this id:", laptop.Id)
+
+	_, err := uuid.Parse(laptop.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "laptop ID is invalid: %v", err)
+	}
+
+	err = server.LaptopStore.Update(laptop)
+	if err != nil {
+		return nil, status.Errorf(storeErrorCode(err), "Can not update laptop in the store:%v", err)
+	}
+
+	log.Printf("Updated laptop with id: %s", laptop.Id)
+	return &pb.UpdateLaptopResponse{Laptop: laptop}, nil
+}
+
+func (server *LaptopServer) PatchLaptop(ctx context.Context, in *pb.PatchLaptopRequest) (*pb.PatchLaptopResponse, error) {
+	laptop := in.GetLaptop()
+	if laptop == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "laptop is not provided")
+	}
+	paths := in.GetUpdateMask().GetPaths()
+	log.Printf("Received Patch Laptop Request with id: %s and paths: %v", laptop.Id, paths)
+
+	_, err := uuid.Parse(laptop.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "laptop ID is invalid: %v", err)
+	}
+
+	if len(paths) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "update mask is not provided")
+	}
+
+	for _, path := range paths {
+		if path == "id" || path == "version" {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid path %q: laptop %s can't be updated", path, path)
 		}
-		laptop.Id = id.String()
 	}
 
-	err := server.LaptopStore.Save(laptop)
+	err = validateFieldMask(laptop.ProtoReflect().Descriptor(), paths)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "update mask is invalid: %v", err)
+	}
+
+	patched, err := server.LaptopStore.Patch(laptop, paths)
+	if err != nil {
+		return nil, status.Errorf(storeErrorCode(err), "Can not patch laptop in the store:%v", err)
+	}
+
+	log.Printf("Patched laptop with id: %s", laptop.Id)
+	return &pb.PatchLaptopResponse{Laptop: patched}, nil
+}
+
+func (server *LaptopServer) DeleteLaptop(ctx context.Context, in *pb.DeleteLaptopRequest) (*pb.DeleteLaptopResponse, error) {
+	laptopID := in.GetId()
+	log.Println("Received Delete Laptop Request with this id:", laptopID)
+
+	err := server.LaptopStore.Delete(laptopID, in.GetVersion())
 	if err != nil {
-		code := codes.Internal
-		if errors.Is(err, ErrAlreadyExists) {
-			code = codes.AlreadyExists
+		return nil, status.Errorf(storeErrorCode(err), "Can not delete laptop from the store:%v", err)
+	}
+
+	// the laptop is gone either way, the images which can't be deleted now are only logged
+	if server.ImageStore != nil {
+		err = server.ImageStore.DeleteLaptopImages(laptopID)
+		if err != nil {
+			log.Printf("cannot delete the images of laptop %s: %v", laptopID, err)
 		}
-		return nil, status.Errorf(code, "Can not save laptop to the store:%v", err)
 	}
 
-	log.Printf("Saved laptop with id: %s", laptop.Id)
-	return &pb.CreateLaptopResponse{Id: laptop.Id}, nil
+	log.Printf("Deleted laptop with id: %s", laptopID)
+	return &pb.DeleteLaptopResponse{}, nil
 }
 
 func (server *LaptopServer) SearchLaptop(in *pb.SearchLaptopRequest, stream pb.LaptopService_SearchLaptopServer) error {
 	filter := in.GetFilter()
-	log.Printf("receive a search-laptop request with filter:%v", filter)
+	sortBy := in.GetSortBy()
+	text := in.GetText()
+	log.Printf("receive a search-laptop request with filter:%v, text:%q and sort keys:%v", filter, text, sortBy)
 
-	err := server.LaptopStore.Search(
-		filter,
-		func(laptop *pb.Laptop) error {
-			res := &pb.SearchLaptopResponse{Laptop: laptop}
+	err := validateSortKeys(sortBy)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "sort keys are invalid: %v", err)
+	}
 
-			err := stream.Send(res)
-			if err != nil {
-				return err
-			}
-			log.Printf("Send laptop with id:%s", laptop.GetId())
-			return nil
-		},
-	)
+	pageSize := int(in.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultSearchPageSize
+	}
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+
+	match, err := compileQuery(in.GetQuery())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "query is invalid: %v", err)
+	}
 
+	// the page size may change from one page to the other, everything else is the query
+	digest, err := queryDigest(&pb.SearchLaptopRequest{Filter: filter, SortBy: sortBy, Query: in.GetQuery(), Text: text})
 	if err != nil {
-		return status.Errorf(codes.Internal, "unexpected error,%w", err)
+		return status.Errorf(codes.Internal, "unexpected error,%v", err)
+	}
+
+	var after *searchCursor
+	if in.GetPageToken() != "" {
+		token, err := server.pageTokens.decode(in.GetPageToken(), digest)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "page token is invalid: %v", err)
+		}
+		after = &token.After
+	}
+
+	page := &searchPage{stream: stream, size: pageSize, tokens: server.pageTokens, query: digest}
+
+	switch {
+	case len(sortBy) == 0 && text == "":
+		// the store already returns the laptops in the order of their IDs, so they are streamed as they are found
+		err = server.LaptopStore.Search(
+			filter,
+			func(laptop *pb.Laptop) error {
+				if (after != nil && laptop.GetId() <= after.ID) || !match(laptop) {
+					return nil
+				}
+				return page.add(laptop, searchCursor{ID: laptop.GetId()})
+			},
+		)
+	case len(sortBy) == 0:
+		// the store returns the laptops in the order of relevance, the cursors hold the score
+		err = server.LaptopStore.SearchText(
+			text,
+			filter,
+			func(laptop *pb.Laptop, score float64) error {
+				cursor := searchCursor{Values: []float64{score}, ID: laptop.GetId()}
+				if (after != nil && compareCursors(cursor, *after, relevanceOrder) <= 0) || !match(laptop) {
+					return nil
+				}
+				return page.add(laptop, cursor)
+			},
+		)
+	default:
+		var laptops []*pb.Laptop
+		var cursors []searchCursor
+		laptops, cursors, err = server.sortedSearch(filter, text, match, sortBy, after)
+		for i := 0; err == nil && i < len(laptops); i++ {
+			err = page.add(laptops[i], cursors[i])
+		}
+	}
+
+	if err == nil {
+		err = page.flush()
+	}
+
+	if err != nil && !errors.Is(err, errPageFull) {
+		return status.Errorf(codes.Internal, "unexpected error,%v", err)
 	}
 	return nil
 }
 
+// FacetLaptops counts the laptops matching the filter and the query by the values of their facets
+func (server *LaptopServer) FacetLaptops(ctx context.Context, in *pb.FacetLaptopsRequest) (*pb.FacetLaptopsResponse, error) {
+	filter := in.GetFilter()
+	log.Printf("receive a facet-laptops request with filter:%v", filter)
+
+	match, err := compileQuery(in.GetQuery())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "query is invalid: %v", err)
+	}
+
+	// the laptops are only read to be counted, so they are not copied
+	facets := newLaptopFacets()
+	err = server.LaptopStore.Scan(filter, func(laptop *pb.Laptop) error {
+		if match(laptop) {
+			facets.add(laptop)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unexpected error,%v", err)
+	}
+
+	return facets.response(), nil
+}
+
+// WatchLaptops streams the changes of the laptops matching the filter. A watcher lagging too far behind is ended with
+// ResourceExhausted and can resume with the token of the last event it received
+func (server *LaptopServer) WatchLaptops(in *pb.WatchLaptopsRequest, stream pb.LaptopService_WatchLaptopsServer) error {
+	filter := in.GetFilter()
+	log.Printf("receive a watch-laptops request with filter:%v and resume token:%q", filter, in.GetResumeToken())
+
+	after := server.LaptopStore.Revision()
+	if in.GetResumeToken() != "" {
+		var err error
+		after, err = decodeResumeToken(in.GetResumeToken())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "resume token is invalid: %v", err)
+		}
+	}
+
+	// the changes are queued while the store is locked, so once the queue is full the watcher is dropped instead of waiting
+	queue := newWatchQueue(filter)
+	cancel, err := server.LaptopStore.Watch(after, queue.push)
+	for errors.Is(err, ErrRevisionExpired) {
+		after, err = server.sendWatchSnapshot(filter, stream)
+		if err != nil {
+			return status.Errorf(codes.Unknown, "Can't send laptops to the client: %v", err)
+		}
+		cancel, err = server.LaptopStore.Watch(after, queue.push)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "unexpected error,%v", err)
+	}
+	defer cancel()
+
+	// a new watcher gets a token right away, so it can't miss a change if it is dropped before the first one
+	if in.GetResumeToken() == "" {
+		err := stream.Send(progressEvent(after))
+		if err != nil {
+			return status.Errorf(codes.Unknown, "Can't send event to the client: %v", err)
+		}
+	}
+
+	send := func(event *pb.WatchLaptopsResponse) error {
+		err := stream.Send(event)
+		if err != nil {
+			return status.Errorf(codes.Unknown, "Can't send event to the client: %v", err)
+		}
+		return nil
+	}
+
+	// the progress events are sent at most once per interval, a later event makes them useless
+	var progress *pb.WatchLaptopsResponse
+	ticker := time.NewTicker(watchProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return contextError(stream.Context())
+		case <-ticker.C:
+			if progress != nil {
+				err := send(progress)
+				if err != nil {
+					return err
+				}
+				progress = nil
+			}
+		case <-queue.ready:
+			items, overflowed := queue.pop()
+			for _, events := range items {
+				if events[0].Type == pb.WatchLaptopsResponse_PROGRESS {
+					progress = events[0]
+					continue
+				}
+
+				progress = nil
+				for _, event := range events {
+					err := send(event)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			if overflowed {
+				// the client resumes right after what it was sent
+				if progress != nil {
+					err := send(progress)
+					if err != nil {
+						return err
+					}
+				}
+				return logError(status.Errorf(codes.ResourceExhausted, "watcher is too slow, resume with the last token"))
+			}
+		}
+	}
+}
+
+// storeErrorCode maps the errors returned by the stores to gRPC codes, a version mismatch means
+// the record was changed concurrently so the client should read it again before retrying
+func storeErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, ErrAlreadyExists):
+		return codes.AlreadyExists
+	case errors.Is(err, ErrVersionMismatch):
+		return codes.Aborted
+	default:
+		return codes.Internal
+	}
+}
+
 func contextError(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
@@ -0,0 +1,1392 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moataz-hamed/pb/pb"
+	"github.com/moataz-hamed/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// newTestLaptopServer creates a server closed when the test ends
+func newTestLaptopServer(t *testing.T, store LaptopStore, imageStore ImageStore, ratingStore RatingStore, options ...LaptopServerOption) *LaptopServer {
+	server, err := NewLaptopServer(store, imageStore, ratingStore, options...)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLaptopServerClose(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	server, err := NewLaptopServer(store, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, store.watchers.notify, 1)
+
+	server.Close()
+	require.Empty(t, store.watchers.notify)
+}
+
+type searchLaptopStream struct {
+	grpc.ServerStream
+	responses []*pb.SearchLaptopResponse
+}
+
+func (stream *searchLaptopStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *searchLaptopStream) Send(res *pb.SearchLaptopResponse) error {
+	stream.responses = append(stream.responses, res)
+	return nil
+}
+
+func TestSearchLaptopPages(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	for i := 0; i < 7; i++ {
+		require.NoError(t, store.Save(sample.NewLaptop()))
+	}
+	server := newTestLaptopServer(t, store, nil, nil)
+	filter := &pb.Filter{MaxPriceUsd: 5000}
+
+	var ids []string
+	req := &pb.SearchLaptopRequest{Filter: filter, PageSize: 3}
+	for pages := 1; ; pages++ {
+		stream := &searchLaptopStream{}
+		require.NoError(t, server.SearchLaptop(req, stream))
+
+		req.PageToken = ""
+		for i, res := range stream.responses {
+			ids = append(ids, res.GetLaptop().GetId())
+			if i < len(stream.responses)-1 {
+				require.Empty(t, res.GetNextPageToken())
+			} else {
+				req.PageToken = res.GetNextPageToken()
+			}
+		}
+
+		if req.PageToken == "" {
+			require.Equal(t, 3, pages)
+			break
+		}
+		require.Len(t, stream.responses, 3)
+
+		// a laptop inserted between two pages must not shift the next one
+		require.NoError(t, store.Save(sample.NewLaptop()))
+	}
+
+	require.IsIncreasing(t, ids)
+
+	req = &pb.SearchLaptopRequest{Filter: filter, PageSize: 3}
+	stream := &searchLaptopStream{}
+	require.NoError(t, server.SearchLaptop(req, stream))
+	token := stream.responses[2].GetNextPageToken()
+
+	req.PageToken = token[:len(token)-2] + "AA"
+	err := server.SearchLaptop(req, &searchLaptopStream{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	req = &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 4000}, PageToken: token}
+	err = server.SearchLaptop(req, &searchLaptopStream{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestSearchLaptopSorted(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	ratingStore := NewInMemoryRatingStore()
+	for i := 0; i < 10; i++ {
+		laptop := sample.NewLaptop()
+		laptop.ReleaseYear = uint32(2020 + i%3)
+		require.NoError(t, store.Save(laptop))
+		_, _, err := ratingStore.Rate(laptop.Id, "user1", sample.RandomLaptopScore())
+		require.NoError(t, err)
+	}
+	server := newTestLaptopServer(t, store, nil, ratingStore)
+
+	sortBy := []*pb.SortKey{
+		{Field: pb.SortKey_RELEASE_YEAR, Descending: true},
+		{Field: pb.SortKey_AVERAGE_RATING},
+	}
+
+	var laptops []*pb.Laptop
+	req := &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 5000}, PageSize: 4, SortBy: sortBy}
+	for {
+		stream := &searchLaptopStream{}
+		require.NoError(t, server.SearchLaptop(req, stream))
+
+		req.PageToken = ""
+		for _, res := range stream.responses {
+			laptops = append(laptops, res.GetLaptop())
+			req.PageToken = res.GetNextPageToken()
+		}
+		if req.PageToken == "" {
+			break
+		}
+	}
+
+	require.Len(t, laptops, 10)
+	for i := 1; i < len(laptops); i++ {
+		previous, err := server.sortValues(laptops[i-1], sortBy)
+		require.NoError(t, err)
+		current, err := server.sortValues(laptops[i], sortBy)
+		require.NoError(t, err)
+
+		order := compareCursors(
+			searchCursor{Values: previous, ID: laptops[i-1].Id},
+			searchCursor{Values: current, ID: laptops[i].Id},
+			sortBy,
+		)
+		require.Negative(t, order)
+	}
+	require.Equal(t, uint32(2022), laptops[0].ReleaseYear)
+
+	req = &pb.SearchLaptopRequest{SortBy: []*pb.SortKey{{}}}
+	err := server.SearchLaptop(req, &searchLaptopStream{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestSearchLaptopSortedUnrated(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	ratingStore := NewInMemoryRatingStore()
+	unrated := map[string]bool{}
+	for i := 0; i < 6; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, store.Save(laptop))
+		if i%2 == 0 {
+			unrated[laptop.Id] = true
+			continue
+		}
+		_, _, err := ratingStore.Rate(laptop.Id, "user1", float64(i))
+		require.NoError(t, err)
+	}
+	server := newTestLaptopServer(t, store, nil, ratingStore)
+
+	// the unrated laptops come last in both directions, the pages go on past them
+	for _, descending := range []bool{false, true} {
+		var laptops []*pb.Laptop
+		req := &pb.SearchLaptopRequest{PageSize: 2, SortBy: []*pb.SortKey{{Field: pb.SortKey_AVERAGE_RATING, Descending: descending}}}
+		for {
+			stream := &searchLaptopStream{}
+			require.NoError(t, server.SearchLaptop(req, stream))
+
+			req.PageToken = ""
+			for _, res := range stream.responses {
+				laptops = append(laptops, res.GetLaptop())
+				req.PageToken = res.GetNextPageToken()
+			}
+			if req.PageToken == "" {
+				break
+			}
+		}
+
+		require.Len(t, laptops, 6)
+		for i, laptop := range laptops {
+			require.Equal(t, i >= 3, unrated[laptop.Id], "descending %v, laptop %d", descending, i)
+		}
+	}
+}
+
+func TestSearchLaptopSortedNonFinite(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	prices := map[string]float64{}
+	for _, price := range []float64{math.NaN(), math.Inf(1), 1500, math.Inf(-1), 900} {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = price
+		require.NoError(t, store.Save(laptop))
+		prices[laptop.Id] = price
+	}
+	server := newTestLaptopServer(t, store, nil, nil)
+
+	// every page ends on a laptop, so each price has to fit in a page token
+	for _, descending := range []bool{false, true} {
+		var got []float64
+		req := &pb.SearchLaptopRequest{PageSize: 1, SortBy: []*pb.SortKey{{Field: pb.SortKey_PRICE_USD, Descending: descending}}}
+		for {
+			stream := &searchLaptopStream{}
+			require.NoError(t, server.SearchLaptop(req, stream))
+
+			req.PageToken = ""
+			for _, res := range stream.responses {
+				got = append(got, prices[res.GetLaptop().GetId()])
+				req.PageToken = res.GetNextPageToken()
+			}
+			if req.PageToken == "" {
+				break
+			}
+		}
+
+		// NaN comes last in both directions, tied with the infinity on that side
+		require.Len(t, got, 5)
+		last := math.Inf(1)
+		if descending {
+			require.Equal(t, []float64{math.Inf(1), 1500, 900}, got[:3])
+			last = math.Inf(-1)
+		} else {
+			require.Equal(t, []float64{math.Inf(-1), 900, 1500}, got[:3])
+		}
+		if math.IsNaN(got[3]) {
+			require.Equal(t, last, got[4])
+		} else {
+			require.Equal(t, last, got[3])
+			require.True(t, math.IsNaN(got[4]))
+		}
+	}
+}
+
+func TestSearchLaptopText(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	var expected []string
+	for i := 0; i < 7; i++ {
+		laptop := sample.NewLaptop()
+		laptop.Name = "Thinkpad"
+		if i%2 == 0 {
+			// the laptops also having the word in their brand are more relevant
+			laptop.Brand = "Thinkpad"
+			expected = append(expected, laptop.Id)
+		}
+		require.NoError(t, store.Save(laptop))
+	}
+	other := sample.NewLaptop()
+	other.Brand = "Apple"
+	other.Name = "Macbook Air"
+	require.NoError(t, store.Save(other))
+	server := newTestLaptopServer(t, store, nil, NewInMemoryRatingStore())
+
+	var ids []string
+	req := &pb.SearchLaptopRequest{Text: "think", PageSize: 3}
+	for {
+		stream := &searchLaptopStream{}
+		require.NoError(t, server.SearchLaptop(req, stream))
+
+		req.PageToken = ""
+		for _, res := range stream.responses {
+			ids = append(ids, res.GetLaptop().GetId())
+			req.PageToken = res.GetNextPageToken()
+		}
+		if req.PageToken == "" {
+			break
+		}
+	}
+
+	require.Len(t, ids, 7)
+	require.ElementsMatch(t, expected, ids[:len(expected)])
+}
+
+func TestFacetLaptops(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	newLaptop := func(brand string, price float64, ram uint32, drivers ...pb.Storage_Driver) {
+		laptop := sample.NewLaptop()
+		laptop.Brand = brand
+		laptop.PriceUsd = price
+		laptop.Ram = &pb.Memory{Value: ram, Unit: pb.Memory_GIGABYTE}
+		laptop.Storages = nil
+		for _, driver := range drivers {
+			laptop.Storages = append(laptop.Storages, &pb.Storage{Driver: driver})
+		}
+		require.NoError(t, store.Save(laptop))
+	}
+
+	newLaptop("Dell", 450, 8, pb.Storage_SSD, pb.Storage_SSD)
+	newLaptop("Lenovo", 1200, 16, pb.Storage_SSD, pb.Storage_HDD)
+	newLaptop("DELL", 1999, 64, pb.Storage_HDD)
+	newLaptop("Apple", 3000, 4)
+	server := newTestLaptopServer(t, store, nil, NewInMemoryRatingStore())
+
+	res, err := server.FacetLaptops(context.Background(), &pb.FacetLaptopsRequest{Filter: &pb.Filter{MaxPriceUsd: 2000}})
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), res.Total)
+
+	counts := func(facets []*pb.FacetCount) map[string]uint32 {
+		result := make(map[string]uint32)
+		for _, facet := range facets {
+			result[facet.Value] = facet.Count
+		}
+		return result
+	}
+	// the brands are counted regardless of their case, like the filters match them
+	require.Equal(t, "DELL", res.Brands[0].Value)
+	require.Equal(t, map[string]uint32{"DELL": 2, "Lenovo": 1}, counts(res.Brands))
+	require.Equal(t, map[string]uint32{"SSD": 2, "HDD": 2}, counts(res.StorageDrivers))
+	require.Equal(t, map[string]uint32{"0-500": 1, "500-1000": 0, "1000-1500": 1, "1500-2000": 1, "2000+": 0}, counts(res.PriceRanges))
+	require.Equal(t, map[string]uint32{"0-8GB": 0, "8GB-16GB": 1, "16GB-32GB": 1, "32GB-64GB": 0, "64GB+": 1}, counts(res.RamSizes))
+
+	res, err = server.FacetLaptops(context.Background(), &pb.FacetLaptopsRequest{Query: `brand = "apple"`})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.Total)
+	require.Equal(t, "2000+", res.PriceRanges[len(res.PriceRanges)-1].Value)
+	require.Equal(t, uint32(1), res.PriceRanges[len(res.PriceRanges)-1].Count)
+
+	_, err = server.FacetLaptops(context.Background(), &pb.FacetLaptopsRequest{Query: `brand <`})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// a deeply nested query is refused instead of overflowing the stack of the parser
+	nested := strings.Repeat("(", 3_000_000)
+	_, err = server.FacetLaptops(context.Background(), &pb.FacetLaptopsRequest{Query: nested})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "position")
+
+	nested = strings.Repeat("(", 1000) + `brand = "apple"` + strings.Repeat(")", 1000)
+	err = server.SearchLaptop(&pb.SearchLaptopRequest{Query: nested}, &searchLaptopStream{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "nested")
+}
+
+type watchLaptopsStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *pb.WatchLaptopsResponse
+}
+
+func (stream *watchLaptopsStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *watchLaptopsStream) Send(res *pb.WatchLaptopsResponse) error {
+	select {
+	case stream.events <- res:
+		return nil
+	case <-stream.ctx.Done():
+		return stream.ctx.Err()
+	}
+}
+
+// watchLaptops runs WatchLaptops until the test ends, the error it returns is sent on the returned channel
+func watchLaptops(t *testing.T, server *LaptopServer, req *pb.WatchLaptopsRequest) (*watchLaptopsStream, chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	stream := &watchLaptopsStream{ctx: ctx, events: make(chan *pb.WatchLaptopsResponse)}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.WatchLaptops(req, stream)
+	}()
+	return stream, done
+}
+
+func TestWatchLaptops(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	cheap := sample.NewLaptop()
+	cheap.PriceUsd = 1000
+	require.NoError(t, store.Save(cheap))
+	server := newTestLaptopServer(t, store, nil, NewInMemoryRatingStore())
+
+	filter := &pb.Filter{MaxPriceUsd: 1500}
+	stream, _ := watchLaptops(t, server, &pb.WatchLaptopsRequest{Filter: filter, ResumeToken: encodeResumeToken(store.Revision())})
+
+	other := sample.NewLaptop()
+	other.PriceUsd = 1200
+	require.NoError(t, store.Save(other))
+	expensive := sample.NewLaptop()
+	expensive.PriceUsd = 3000
+	require.NoError(t, store.Save(expensive))
+	cheap.Name = "changed"
+	require.NoError(t, store.Update(cheap))
+	require.NoError(t, store.Delete(other.Id, 0))
+
+	expected := []struct {
+		kind pb.WatchLaptopsResponse_Type
+		id   string
+	}{
+		{pb.WatchLaptopsResponse_CREATED, other.Id},
+		{pb.WatchLaptopsResponse_UPDATED, cheap.Id},
+		{pb.WatchLaptopsResponse_DELETED, other.Id},
+	}
+
+	var events []*pb.WatchLaptopsResponse
+	for _, want := range expected {
+		event := <-stream.events
+		require.Equal(t, want.kind, event.Type)
+		require.Equal(t, want.id, event.Laptop.Id)
+		require.Equal(t, encodeResumeToken(event.Revision), event.ResumeToken)
+		events = append(events, event)
+	}
+
+	// resuming sends the changes after the token again
+	stream, _ = watchLaptops(t, server, &pb.WatchLaptopsRequest{Filter: filter, ResumeToken: events[0].ResumeToken})
+	require.Equal(t, events[1].Revision, (<-stream.events).Revision)
+	require.Equal(t, events[2].Revision, (<-stream.events).Revision)
+
+	// a token which can't be resumed gives the laptops matching the filter again
+	stream, _ = watchLaptops(t, server, &pb.WatchLaptopsRequest{Filter: filter, ResumeToken: "1000"})
+	event := <-stream.events
+	require.Equal(t, pb.WatchLaptopsResponse_RESET, event.Type)
+	event = <-stream.events
+	require.Equal(t, pb.WatchLaptopsResponse_CREATED, event.Type)
+	require.Equal(t, cheap.Id, event.Laptop.Id)
+	require.Equal(t, encodeResumeToken(store.Revision()), event.ResumeToken)
+
+	_, err := server.LaptopStore.Watch(1000, func(changes *LaptopChanges) {})
+	require.ErrorIs(t, err, ErrRevisionExpired)
+}
+
+func TestWatchLaptopsSlowWatcher(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	server := newTestLaptopServer(t, store, nil, NewInMemoryRatingStore())
+	stream, done := watchLaptops(t, server, &pb.WatchLaptopsRequest{})
+
+	// the first progress event is sent once the watcher is subscribed
+	require.Equal(t, pb.WatchLaptopsResponse_PROGRESS, (<-stream.events).Type)
+
+	// nobody receives the events, saving must not wait for the watcher. The batch it took before
+	// blocking is not counted in its queue, so the queue is filled twice
+	saved := 2*watchBufferSize + 1
+	for i := 0; i < saved; i++ {
+		require.NoError(t, store.Save(sample.NewLaptop()))
+	}
+
+	received := 0
+	var last *pb.WatchLaptopsResponse
+	for {
+		select {
+		case last = <-stream.events:
+			received++
+			continue
+		case err := <-done:
+			require.Equal(t, codes.ResourceExhausted, status.Code(err))
+		}
+		break
+	}
+	require.Less(t, received, saved)
+
+	// the watcher resumes after the last event it was sent, which may be a progress event at the last revision
+	stream, _ = watchLaptops(t, server, &pb.WatchLaptopsRequest{ResumeToken: last.ResumeToken})
+	require.NoError(t, store.Save(sample.NewLaptop()))
+	require.Equal(t, last.Revision+1, (<-stream.events).Revision)
+}
+
+func TestWatchLaptopsProgress(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	server := newTestLaptopServer(t, store, nil, NewInMemoryRatingStore())
+	newExpensive := func() *pb.Laptop {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 3000
+		return laptop
+	}
+	require.NoError(t, store.Save(newExpensive()))
+
+	// a new watcher is given a token before any change
+	filter := &pb.Filter{MaxPriceUsd: 1500}
+	stream, _ := watchLaptops(t, server, &pb.WatchLaptopsRequest{Filter: filter})
+	event := <-stream.events
+	require.Equal(t, pb.WatchLaptopsResponse_PROGRESS, event.Type)
+	require.Equal(t, encodeResumeToken(1), event.ResumeToken)
+
+	// the changes matching nothing move the token forward
+	require.NoError(t, store.Save(newExpensive()))
+	require.NoError(t, store.Save(newExpensive()))
+	event = <-stream.events
+	require.Equal(t, pb.WatchLaptopsResponse_PROGRESS, event.Type)
+	require.Nil(t, event.Laptop)
+	require.Equal(t, encodeResumeToken(3), event.ResumeToken)
+
+	// only the changes matching the filter count against the buffer of a watcher far behind
+	for i := 0; i < 2*watchBufferSize; i++ {
+		require.NoError(t, store.Save(newExpensive()))
+	}
+	cheap := sample.NewLaptop()
+	cheap.PriceUsd = 1000
+	require.NoError(t, store.Save(cheap))
+
+	stream, done := watchLaptops(t, server, &pb.WatchLaptopsRequest{Filter: filter, ResumeToken: event.ResumeToken})
+	select {
+	case event = <-stream.events:
+	case err := <-done:
+		require.NoError(t, err)
+	}
+	require.Equal(t, pb.WatchLaptopsResponse_CREATED, event.Type)
+	require.Equal(t, cheap.Id, event.Laptop.Id)
+	require.Equal(t, encodeResumeToken(store.Revision()), event.ResumeToken)
+}
+
+type batchCreateLaptopsStream struct {
+	grpc.ServerStream
+	requests []*pb.BatchCreateLaptopsRequest
+	response *pb.BatchCreateLaptopsResponse
+}
+
+func (stream *batchCreateLaptopsStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *batchCreateLaptopsStream) Recv() (*pb.BatchCreateLaptopsRequest, error) {
+	if len(stream.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := stream.requests[0]
+	stream.requests = stream.requests[1:]
+	return req, nil
+}
+
+func (stream *batchCreateLaptopsStream) SendAndClose(res *pb.BatchCreateLaptopsResponse) error {
+	stream.response = res
+	return nil
+}
+
+func TestBatchCreateLaptops(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	existing := sample.NewLaptop()
+	require.NoError(t, store.Save(existing))
+	server := newTestLaptopServer(t, store, nil, NewInMemoryRatingStore())
+
+	batch := func(allOrNothing bool) *batchCreateLaptopsStream {
+		noID := sample.NewLaptop()
+		noID.Id = ""
+		invalidID := sample.NewLaptop()
+		invalidID.Id = "invalid"
+		duplicate := sample.NewLaptop()
+
+		stream := &batchCreateLaptopsStream{}
+		for _, laptop := range []*pb.Laptop{noID, invalidID, proto.Clone(existing).(*pb.Laptop), duplicate, proto.Clone(duplicate).(*pb.Laptop)} {
+			stream.requests = append(stream.requests, &pb.BatchCreateLaptopsRequest{
+				Value: &pb.BatchCreateLaptopsRequest_Laptop{Laptop: laptop},
+			})
+		}
+		if allOrNothing {
+			options := &pb.BatchCreateLaptopsRequest{
+				Value: &pb.BatchCreateLaptopsRequest_Options{Options: &pb.BatchCreateOptions{AllOrNothing: true}},
+			}
+			stream.requests = append([]*pb.BatchCreateLaptopsRequest{options}, stream.requests...)
+		}
+		return stream
+	}
+
+	codesOf := func(res *pb.BatchCreateLaptopsResponse) []codes.Code {
+		var result []codes.Code
+		for _, item := range res.Results {
+			result = append(result, codes.Code(item.Code))
+		}
+		return result
+	}
+
+	stream := batch(true)
+	require.NoError(t, server.BatchCreateLaptops(stream))
+	require.Equal(t, uint32(0), stream.response.CreatedCount)
+	require.Equal(t, []codes.Code{codes.Aborted, codes.InvalidArgument, codes.AlreadyExists, codes.Aborted, codes.AlreadyExists}, codesOf(stream.response))
+	require.Equal(t, uint64(1), store.Revision())
+
+	stream = batch(false)
+	require.NoError(t, server.BatchCreateLaptops(stream))
+	require.Equal(t, uint32(2), stream.response.CreatedCount)
+	require.Equal(t, []codes.Code{codes.OK, codes.InvalidArgument, codes.AlreadyExists, codes.OK, codes.AlreadyExists}, codesOf(stream.response))
+
+	created, err := store.Find(stream.response.Results[0].Id)
+	require.NoError(t, err)
+	require.Equal(t, stream.response.Results[0].Version, created.Version)
+
+	// valid laptops are all created in one change
+	stream = &batchCreateLaptopsStream{requests: []*pb.BatchCreateLaptopsRequest{
+		{Value: &pb.BatchCreateLaptopsRequest_Options{Options: &pb.BatchCreateOptions{AllOrNothing: true}}},
+		{Value: &pb.BatchCreateLaptopsRequest_Laptop{Laptop: sample.NewLaptop()}},
+		{Value: &pb.BatchCreateLaptopsRequest_Laptop{Laptop: sample.NewLaptop()}},
+	}}
+	revision := store.Revision()
+	require.NoError(t, server.BatchCreateLaptops(stream))
+	require.Equal(t, uint32(2), stream.response.CreatedCount)
+	require.Equal(t, revision+1, stream.response.Results[1].Version)
+	require.Equal(t, revision+1, store.Revision())
+}
+
+func TestGetLaptop(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+	server := newTestLaptopServer(t, store, nil, nil)
+
+	res, err := server.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.Id})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(laptop, res.Laptop))
+
+	_, err = server.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: uuid.NewString()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestUpdateLaptop(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+	server := newTestLaptopServer(t, store, nil, nil)
+
+	// an update of the stored version moves the laptop to a new one
+	updated := proto.Clone(laptop).(*pb.Laptop)
+	updated.PriceUsd = 1234
+	res, err := server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: updated})
+	require.NoError(t, err)
+	require.Greater(t, res.Laptop.Version, laptop.Version)
+	requireStoredLaptop(t, store, res.Laptop)
+
+	// the version read before the update is stale now
+	stale := proto.Clone(laptop).(*pb.Laptop)
+	stale.PriceUsd = 999
+	_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: stale})
+	require.Equal(t, codes.Aborted, status.Code(err))
+	requireStoredLaptop(t, store, res.Laptop)
+
+	// without a version the update is unconditional
+	stale.Version = 0
+	_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: stale})
+	require.NoError(t, err)
+	found, err := store.Find(laptop.Id)
+	require.NoError(t, err)
+	require.Equal(t, 999.0, found.PriceUsd)
+
+	unknown := sample.NewLaptop()
+	_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: unknown})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	unknown.Id = "invalid-uuid"
+	_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: unknown})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestPatchLaptop(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+	server := newTestLaptopServer(t, store, nil, nil)
+
+	patch := func(laptop *pb.Laptop, paths ...string) (*pb.PatchLaptopResponse, error) {
+		return server.PatchLaptop(context.Background(), &pb.PatchLaptopRequest{
+			Laptop:     laptop,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+		})
+	}
+
+	// only the fields of the mask are overwritten
+	changes := proto.Clone(laptop).(*pb.Laptop)
+	changes.PriceUsd = 1234
+	changes.Name = "ignored"
+	res, err := patch(changes, "price_usd")
+	require.NoError(t, err)
+	require.Greater(t, res.Laptop.Version, laptop.Version)
+	require.Equal(t, 1234.0, res.Laptop.PriceUsd)
+	require.Equal(t, laptop.Name, res.Laptop.Name)
+	requireStoredLaptop(t, store, res.Laptop)
+
+	// the version read before the patch is stale now
+	_, err = patch(changes, "price_usd")
+	require.Equal(t, codes.Aborted, status.Code(err))
+
+	changes.Version = 0
+	for _, path := range []string{"id", "version"} {
+		_, err = patch(changes, "price_usd", path)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), path)
+		require.Contains(t, status.Convert(err).Message(), path)
+	}
+
+	_, err = patch(changes, "cpu.turbo_ghz")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "cpu.turbo_ghz")
+
+	_, err = patch(changes)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = patch(sample.NewLaptop(), "price_usd")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	requireStoredLaptop(t, store, res.Laptop)
+}
+
+func TestDeleteLaptop(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+	server := newTestLaptopServer(t, store, nil, nil)
+
+	// a laptop changed since it was read is not deleted
+	_, err := server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id, Version: laptop.Version + 1})
+	require.Equal(t, codes.Aborted, status.Code(err))
+	requireStoredLaptop(t, store, laptop)
+
+	_, err = server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id, Version: laptop.Version})
+	require.NoError(t, err)
+	_, err = server.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.Id})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+type rateLaptopStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	requests  []*pb.RateLaptopRequest
+	responses []*pb.RateLaptopResponse
+}
+
+func newRateLaptopStream(username string, requests ...*pb.RateLaptopRequest) *rateLaptopStream {
+	ctx := context.WithValue(context.Background(), claimsKey{}, &UserClaims{Username: username, Role: "user"})
+	return &rateLaptopStream{ctx: ctx, requests: requests}
+}
+
+func (stream *rateLaptopStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *rateLaptopStream) Recv() (*pb.RateLaptopRequest, error) {
+	if len(stream.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := stream.requests[0]
+	stream.requests = stream.requests[1:]
+	return req, nil
+}
+
+func (stream *rateLaptopStream) Send(res *pb.RateLaptopResponse) error {
+	stream.responses = append(stream.responses, res)
+	return nil
+}
+
+func TestRateLaptop(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+	server := newTestLaptopServer(t, store, nil, NewInMemoryRatingStore())
+
+	// a user streaming scores only keeps their last one
+	stream := newRateLaptopStream("user1",
+		&pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 10},
+		&pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 10},
+		&pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 4},
+	)
+	require.NoError(t, server.RateLaptop(stream))
+	require.Equal(t, pb.RateLaptopResponse_CREATED, stream.responses[0].Result)
+	require.Equal(t, pb.RateLaptopResponse_REPLACED, stream.responses[1].Result)
+	require.Equal(t, uint32(1), stream.responses[2].RatedCount)
+	require.Equal(t, 4.0, stream.responses[2].AverageScore)
+
+	stream = newRateLaptopStream("user2", &pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 8})
+	require.NoError(t, server.RateLaptop(stream))
+	require.Equal(t, uint32(2), stream.responses[0].RatedCount)
+	require.Equal(t, 6.0, stream.responses[0].AverageScore)
+
+	stream = newRateLaptopStream("user1", &pb.RateLaptopRequest{LaptopId: laptop.Id, Retract: true})
+	require.NoError(t, server.RateLaptop(stream))
+	require.Equal(t, pb.RateLaptopResponse_RETRACTED, stream.responses[0].Result)
+	require.Equal(t, uint32(1), stream.responses[0].RatedCount)
+	require.Equal(t, 8.0, stream.responses[0].AverageScore)
+
+	// a laptop or a score which does not exist only fails its own request
+	stream = newRateLaptopStream("user1",
+		&pb.RateLaptopRequest{LaptopId: laptop.Id, Retract: true},
+		&pb.RateLaptopRequest{LaptopId: "unknown", Score: 5},
+		&pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 6},
+	)
+	require.NoError(t, server.RateLaptop(stream))
+	require.Len(t, stream.responses, 3)
+	require.Equal(t, codes.NotFound, codes.Code(stream.responses[0].ErrorCode))
+	require.Equal(t, codes.NotFound, codes.Code(stream.responses[1].ErrorCode))
+	require.Equal(t, "unknown", stream.responses[1].LaptopId)
+	require.Equal(t, codes.OK, codes.Code(stream.responses[2].ErrorCode))
+	require.Equal(t, uint32(2), stream.responses[2].RatedCount)
+
+	stream = &rateLaptopStream{ctx: context.Background()}
+	require.Equal(t, codes.Unauthenticated, status.Code(server.RateLaptop(stream)))
+}
+
+func TestRateLaptopInvalidScore(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+	server := newTestLaptopServer(t, store, nil, NewInMemoryRatingStore())
+
+	testCases := []struct {
+		score float64
+		code  codes.Code
+	}{
+		{-1, codes.InvalidArgument},
+		{math.NaN(), codes.InvalidArgument},
+		{math.Inf(1), codes.InvalidArgument},
+		{1e9, codes.InvalidArgument},
+		{7.5, codes.InvalidArgument},
+		{7, codes.OK},
+	}
+
+	var requests []*pb.RateLaptopRequest
+	for _, tc := range testCases {
+		requests = append(requests, &pb.RateLaptopRequest{LaptopId: laptop.Id, Score: tc.score})
+	}
+
+	// the bad scores are reported on the stream and the last one is still rated
+	stream := newRateLaptopStream("user1", requests...)
+	require.NoError(t, server.RateLaptop(stream))
+	require.Len(t, stream.responses, len(testCases))
+	for i, tc := range testCases {
+		require.Equal(t, tc.code, codes.Code(stream.responses[i].ErrorCode), "score %v", tc.score)
+	}
+	require.Equal(t, uint32(1), stream.responses[len(testCases)-1].RatedCount)
+
+	server = newTestLaptopServer(t, store, nil, NewInMemoryRatingStore(), WithRatingScale(RatingScale{Min: 0, Max: 5, HalfSteps: true}))
+	stream = newRateLaptopStream("user1",
+		&pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 4.5},
+		&pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 4.25},
+		&pb.RateLaptopRequest{LaptopId: laptop.Id, Score: 7},
+	)
+	require.NoError(t, server.RateLaptop(stream))
+	require.Equal(t, codes.OK, codes.Code(stream.responses[0].ErrorCode))
+	require.Equal(t, codes.InvalidArgument, codes.Code(stream.responses[1].ErrorCode))
+	require.Equal(t, codes.InvalidArgument, codes.Code(stream.responses[2].ErrorCode))
+	require.Equal(t, 4.5, stream.responses[0].AverageScore)
+}
+
+func TestGetLaptopRatingStats(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	rated := sample.NewLaptop()
+	require.NoError(t, store.Save(rated))
+	unrated := sample.NewLaptop()
+	require.NoError(t, store.Save(unrated))
+
+	ratingStore := NewInMemoryRatingStore()
+	_, _, err := ratingStore.Rate(rated.Id, "user1", 3)
+	require.NoError(t, err)
+	_, _, err = ratingStore.Rate(rated.Id, "user2", 9)
+	require.NoError(t, err)
+	server := newTestLaptopServer(t, store, nil, ratingStore)
+
+	res, err := server.GetLaptopRatingStats(context.Background(), &pb.GetLaptopRatingStatsRequest{LaptopIds: []string{unrated.Id, rated.Id}})
+	require.NoError(t, err)
+	require.Len(t, res.Stats, 2)
+	require.Equal(t, unrated.Id, res.Stats[0].LaptopId)
+	require.Zero(t, res.Stats[0].Count)
+
+	stats := res.Stats[1]
+	require.Equal(t, uint32(2), stats.Count)
+	require.Equal(t, 6.0, stats.Mean)
+	require.Equal(t, 6.0, stats.Median)
+	require.Equal(t, 3.0, stats.StandardDeviation)
+	require.Len(t, stats.Histogram, 2)
+	require.Equal(t, 9.0, stats.Histogram[1].Score)
+
+	_, err = server.GetLaptopRatingStats(context.Background(), &pb.GetLaptopRatingStatsRequest{LaptopIds: []string{sample.NewLaptop().Id}})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = server.GetLaptopRatingStats(context.Background(), &pb.GetLaptopRatingStatsRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestLaptopReviews(t *testing.T) {
+	t.Parallel()
+
+	db, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	reviewStores := map[string]ReviewStore{
+		"memory": NewInMemoryReviewStore(),
+		"bolt":   db.ReviewStore(),
+	}
+	for name, reviewStore := range reviewStores {
+		t.Run(name, func(t *testing.T) {
+			testLaptopReviews(t, reviewStore)
+		})
+	}
+}
+
+func testLaptopReviews(t *testing.T, reviewStore ReviewStore) {
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+	ratingStore := NewInMemoryRatingStore()
+	server := newTestLaptopServer(t, store, nil, ratingStore, WithReviewStore(reviewStore))
+
+	submit := func(username string, score float64) *pb.SubmitReviewResponse {
+		ctx := context.WithValue(context.Background(), claimsKey{}, &UserClaims{Username: username, Role: "user"})
+		res, err := server.SubmitReview(ctx, &pb.SubmitReviewRequest{LaptopId: laptop.Id, Title: "title", Body: "body of " + username, Score: score})
+		require.NoError(t, err)
+		return res
+	}
+
+	for _, username := range []string{"user1", "user2", "user3", "user4", "user5"} {
+		res := submit(username, 8)
+		require.False(t, res.Replaced)
+		require.Equal(t, pb.Review_PENDING, res.Review.Status)
+	}
+
+	// a user writing again replaces their review and their rating
+	res := submit("user1", 2)
+	require.True(t, res.Replaced)
+	rating, err := ratingStore.Find(laptop.Id)
+	require.NoError(t, err)
+	require.Equal(t, uint32(5), rating.Count)
+	require.Equal(t, 34.0, rating.Sum)
+
+	hidden := submit("user2", 4).Review
+	moderated, err := server.ModerateReview(context.Background(), &pb.ModerateReviewRequest{ReviewId: hidden.Id, Status: pb.Review_HIDDEN})
+	require.NoError(t, err)
+	require.Equal(t, pb.Review_HIDDEN, moderated.Review.Status)
+
+	// posting again does not undo the moderation
+	resubmitted := submit("user2", 5)
+	require.True(t, resubmitted.Replaced)
+	require.Equal(t, pb.Review_HIDDEN, resubmitted.Review.Status)
+	found, err := reviewStore.Find(resubmitted.Review.Id)
+	require.NoError(t, err)
+	require.Equal(t, pb.Review_HIDDEN, found.Status)
+
+	var reviews []*pb.Review
+	req := &pb.ListLaptopReviewsRequest{LaptopId: laptop.Id, PageSize: 2}
+	for {
+		res, err := server.ListLaptopReviews(context.Background(), req)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(res.Reviews), 2)
+		reviews = append(reviews, res.Reviews...)
+
+		req.PageToken = res.NextPageToken
+		if req.PageToken == "" {
+			break
+		}
+	}
+
+	require.Len(t, reviews, 4)
+	for i, review := range reviews {
+		require.NotEqual(t, "user2", review.Username)
+		if i > 0 {
+			require.Negative(t, compareReviews(reviews[i-1], review))
+		}
+	}
+
+	_, err = server.ModerateReview(context.Background(), &pb.ModerateReviewRequest{ReviewId: hidden.Id, Status: pb.Review_PENDING})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = server.ModerateReview(context.Background(), &pb.ModerateReviewRequest{ReviewId: "unknown", Status: pb.Review_APPROVED})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = server.SubmitReview(context.Background(), &pb.SubmitReviewRequest{LaptopId: laptop.Id, Body: "body", Score: 5})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestListTopRatedLaptops(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	ratingStore := NewInMemoryRatingStore()
+	newLaptop := func(price float64, scores ...float64) *pb.Laptop {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = price
+		require.NoError(t, store.Save(laptop))
+		for i, score := range scores {
+			_, _, err := ratingStore.Rate(laptop.Id, fmt.Sprintf("user%d", i), score)
+			require.NoError(t, err)
+		}
+		return laptop
+	}
+
+	// one perfect vote weighs less than many good ones
+	popular := newLaptop(1000, 9, 9, 9, 9, 9, 9, 9, 9, 9, 9)
+	lucky := newLaptop(800, 10)
+	expensive := newLaptop(3000, 8, 8, 8, 8, 8, 8, 8, 8)
+	poor := newLaptop(500, 2, 3, 2)
+	newLaptop(500)
+
+	// the ratings made before the server starts are ranked as well
+	server := newTestLaptopServer(t, store, nil, ratingStore, WithMinVotes(5))
+
+	top := func(req *pb.ListTopRatedLaptopsRequest) []string {
+		stream := &listTopRatedLaptopsStream{}
+		require.NoError(t, server.ListTopRatedLaptops(req, stream))
+		var ids []string
+		for _, res := range stream.responses {
+			ids = append(ids, res.Laptop.Id)
+		}
+		return ids
+	}
+
+	require.Equal(t, []string{popular.Id, expensive.Id, lucky.Id, poor.Id}, top(&pb.ListTopRatedLaptopsRequest{}))
+	require.Equal(t, []string{popular.Id, lucky.Id}, top(&pb.ListTopRatedLaptopsRequest{Filter: &pb.Filter{MaxPriceUsd: 2000}, Limit: 2}))
+	require.Equal(t, []string{popular.Id, expensive.Id}, top(&pb.ListTopRatedLaptopsRequest{MinVotes: 5}))
+
+	// the ranking follows the new scores
+	stream := newRateLaptopStream("user1", &pb.RateLaptopRequest{LaptopId: poor.Id, Score: 10})
+	for i := 0; i < 10; i++ {
+		stream.requests = append(stream.requests, &pb.RateLaptopRequest{LaptopId: lucky.Id, Score: 10})
+	}
+	require.NoError(t, server.RateLaptop(stream))
+	for i := 10; i < 20; i++ {
+		stream := newRateLaptopStream(fmt.Sprintf("user%d", i), &pb.RateLaptopRequest{LaptopId: lucky.Id, Score: 10})
+		require.NoError(t, server.RateLaptop(stream))
+	}
+	require.Equal(t, []string{lucky.Id, popular.Id, expensive.Id, poor.Id}, top(&pb.ListTopRatedLaptopsRequest{}))
+
+	// the deleted laptops leave the board, and the ones deleted before the server starts are not ranked
+	require.NoError(t, store.Delete(popular.Id, 0))
+	require.Equal(t, []string{lucky.Id, expensive.Id, poor.Id}, top(&pb.ListTopRatedLaptopsRequest{}))
+	require.NotContains(t, server.leaderboard.byLaptop, popular.Id)
+	require.Len(t, server.leaderboard.entries, 3)
+
+	server = newTestLaptopServer(t, store, nil, ratingStore)
+	require.NotContains(t, server.leaderboard.byLaptop, popular.Id)
+	require.Len(t, server.leaderboard.entries, 3)
+}
+
+type listTopRatedLaptopsStream struct {
+	grpc.ServerStream
+	responses []*pb.ListTopRatedLaptopsResponse
+}
+
+func (stream *listTopRatedLaptopsStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *listTopRatedLaptopsStream) Send(res *pb.ListTopRatedLaptopsResponse) error {
+	stream.responses = append(stream.responses, res)
+	return nil
+}
+
+type downloadImageStream struct {
+	grpc.ServerStream
+	responses []*pb.DownloadImageResponse
+}
+
+func (stream *downloadImageStream) Context() context.Context {
+	return context.Background()
+}
+
+// Send copies the response like gRPC serializes it, the server reuses the buffer of the chunks
+func (stream *downloadImageStream) Send(res *pb.DownloadImageResponse) error {
+	stream.responses = append(stream.responses, proto.Clone(res).(*pb.DownloadImageResponse))
+	return nil
+}
+
+func TestDownloadImage(t *testing.T) {
+	t.Parallel()
+
+	imageStore := NewDiskImageStore(t.TempDir(), NewInMemoryImageInfoStore())
+	data := make([]byte, imageChunkSize*2+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	imageID, _, err := imageStore.Save("laptop1", ".jpg", bytes.NewReader(data))
+	require.NoError(t, err)
+	server := newTestLaptopServer(t, NewInMemoryLaptopStore(), imageStore, NewInMemoryRatingStore())
+
+	stream := &downloadImageStream{}
+	require.NoError(t, server.DownloadImage(&pb.DownloadImageRequest{ImageId: imageID}, stream))
+	require.Len(t, stream.responses, 4)
+
+	info := stream.responses[0].GetInfo()
+	require.Equal(t, "laptop1", info.LaptopId)
+	require.Equal(t, ".jpg", info.ImageTypes)
+	require.Equal(t, uint64(len(data)), info.Size)
+
+	var downloaded []byte
+	for _, res := range stream.responses[1:] {
+		downloaded = append(downloaded, res.GetChunkData()...)
+	}
+	require.Equal(t, data, downloaded)
+
+	err = server.DownloadImage(&pb.DownloadImageRequest{ImageId: "unknown"}, &downloadImageStream{})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	err = server.DownloadImage(&pb.DownloadImageRequest{ImageId: imageID, Variant: 128}, &downloadImageStream{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+type uploadImageStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests []*pb.UploadImageRequest
+	response *pb.UploadImageResponse
+}
+
+func newUploadImageStream(ctx context.Context, laptopID string, chunks ...[]byte) *uploadImageStream {
+	requests := []*pb.UploadImageRequest{{
+		Data: &pb.UploadImageRequest_Into{Into: &pb.ImageInfo{LaptopId: laptopID, ImageTypes: ".png"}},
+	}}
+	for _, chunk := range chunks {
+		requests = append(requests, &pb.UploadImageRequest{Data: &pb.UploadImageRequest_ChunkData{ChunkData: chunk}})
+	}
+	return &uploadImageStream{ctx: ctx, requests: requests}
+}
+
+func (stream *uploadImageStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *uploadImageStream) Recv() (*pb.UploadImageRequest, error) {
+	if len(stream.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := stream.requests[0]
+	stream.requests = stream.requests[1:]
+	return req, nil
+}
+
+func (stream *uploadImageStream) SendAndClose(res *pb.UploadImageResponse) error {
+	stream.response = res
+	return nil
+}
+
+// newPNG encodes a PNG image of the given size
+func TestDeleteLaptopImages(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(laptop))
+	imageStore := NewDiskImageStore(t.TempDir(), NewInMemoryImageInfoStore())
+	imageID, _, err := imageStore.Save(laptop.Id, ".png", bytes.NewReader(newPNG(t, 2, 2)))
+	require.NoError(t, err)
+	server := newTestLaptopServer(t, store, imageStore, nil)
+
+	// the images of a deleted laptop are deleted with it
+	_, err = server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id})
+	require.NoError(t, err)
+	_, _, err = imageStore.Open(imageID, 0)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func newPNG(t *testing.T, width int, height int) []byte {
+	var buffer bytes.Buffer
+	require.NoError(t, png.Encode(&buffer, image.NewGray(image.Rect(0, 0, width, height))))
+	return buffer.Bytes()
+}
+
+func sha256Hex(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+func TestUploadImage(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	imageFolder := t.TempDir()
+	imageStore := NewDiskImageStore(imageFolder, NewInMemoryImageInfoStore())
+	data := newPNG(t, 4, 4)
+	server := newTestLaptopServer(t, laptopStore, imageStore, NewInMemoryRatingStore(), WithMaxImageSize(int64(len(data))))
+
+	stream := newUploadImageStream(context.Background(), laptop.Id, data[:10], data[10:])
+	require.NoError(t, server.UploadImage(stream))
+	require.Equal(t, uint64(len(data)), stream.response.Size)
+
+	info, file, err := imageStore.Open(stream.response.Id, 0)
+	require.NoError(t, err)
+	defer file.Close()
+	require.Equal(t, laptop.Id, info.LaptopID)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+	require.False(t, stream.response.Deduplicated)
+
+	stream = newUploadImageStream(context.Background(), laptop.Id, data)
+	require.NoError(t, server.UploadImage(stream))
+	require.True(t, stream.response.Deduplicated)
+
+	// the failed uploads leave no file behind
+	stream = newUploadImageStream(context.Background(), laptop.Id, data, []byte("!"))
+	err = server.UploadImage(stream)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream = newUploadImageStream(ctx, laptop.Id, data)
+	err = server.UploadImage(stream)
+	require.Equal(t, codes.Canceled, status.Code(err))
+
+	entries, err := os.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, info.Path, filepath.Join(imageFolder, entries[0].Name()))
+}
+
+func TestUploadImageVerified(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	imageFolder := t.TempDir()
+	imageStore := NewDiskImageStore(imageFolder, NewInMemoryImageInfoStore())
+	server := newTestLaptopServer(t, laptopStore, imageStore, NewInMemoryRatingStore(), WithMaxImageSize(10000))
+	data := newPNG(t, 32, 32)
+
+	testCases := []struct {
+		name      string
+		imageType string
+		sha256    string
+		data      []byte
+		code      codes.Code
+	}{
+		{name: "digest", imageType: ".png", sha256: strings.ToUpper(sha256Hex(data)), data: data, code: codes.OK},
+		{name: "no digest", imageType: ".png", data: data, code: codes.OK},
+		{name: "unknown extension", imageType: ".image", data: data, code: codes.OK},
+		{name: "digest mismatch", imageType: ".png", sha256: sha256Hex(data[1:]), data: data, code: codes.DataLoss},
+		{name: "extension mismatch", imageType: ".jpg", data: data, code: codes.InvalidArgument},
+		{name: "executable", imageType: ".png", data: append([]byte("MZ\x90\x00"), data...), code: codes.InvalidArgument},
+		{name: "empty", imageType: ".png", data: nil, code: codes.InvalidArgument},
+	}
+
+	for _, tc := range testCases {
+		stream := newUploadImageStream(context.Background(), laptop.Id, tc.data)
+		stream.requests[0].GetInto().ImageTypes = tc.imageType
+		stream.requests[0].GetInto().Sha256 = tc.sha256
+		err := server.UploadImage(stream)
+		require.Equal(t, tc.code, status.Code(err), tc.name)
+	}
+
+	// the images uploaded share the file of their data
+	entries, err := os.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	gif := newTestLaptopServer(t, laptopStore, imageStore, NewInMemoryRatingStore(), WithImageContentTypes("image/gif"))
+	err = gif.UploadImage(newUploadImageStream(context.Background(), laptop.Id, data))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+type uploadChunksStream struct {
+	grpc.ServerStream
+	requests []*pb.UploadChunkRequest
+	response *pb.UploadChunkResponse
+}
+
+func (stream *uploadChunksStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *uploadChunksStream) Recv() (*pb.UploadChunkRequest, error) {
+	if len(stream.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := stream.requests[0]
+	stream.requests = stream.requests[1:]
+	return req, nil
+}
+
+func (stream *uploadChunksStream) SendAndClose(res *pb.UploadChunkResponse) error {
+	stream.response = res
+	return nil
+}
+
+func TestResumableUpload(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	uploadStore, err := NewDiskUploadStore(t.TempDir(), time.Hour)
+	require.NoError(t, err)
+	imageStore := NewDiskImageStore(t.TempDir(), NewInMemoryImageInfoStore())
+	data := newPNG(t, 4, 4)
+	server := newTestLaptopServer(t, laptopStore, imageStore, NewInMemoryRatingStore(), WithUploadStore(uploadStore), WithMaxImageSize(int64(len(data))))
+	ctx := context.Background()
+
+	_, err = server.StartUpload(ctx, &pb.StartUploadRequest{Info: &pb.ImageInfo{LaptopId: "unknown"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	info := &pb.ImageInfo{LaptopId: laptop.Id, ImageTypes: ".png", Sha256: sha256Hex(data)}
+	upload, err := server.StartUpload(ctx, &pb.StartUploadRequest{Info: info})
+	require.NoError(t, err)
+	uploadID := upload.GetUploadId()
+
+	chunk := func(offset int, end int) *pb.UploadChunkRequest {
+		return &pb.UploadChunkRequest{UploadId: uploadID, Offset: uint64(offset), ChunkData: data[offset:end]}
+	}
+
+	// the chunks written before the stream fails are kept
+	stream := &uploadChunksStream{requests: []*pb.UploadChunkRequest{chunk(0, 5), chunk(0, 5)}}
+	err = server.UploadChunks(stream)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	query, err := server.QueryUpload(ctx, &pb.QueryUploadRequest{UploadId: uploadID})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), query.GetOffset())
+	require.True(t, query.GetExpireTime().AsTime().After(time.Now()))
+
+	size := uint64(len(data))
+	_, err = server.FinalizeUpload(ctx, &pb.FinalizeUploadRequest{UploadId: uploadID, Size: size})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	tooBig := &pb.UploadChunkRequest{UploadId: uploadID, Offset: size, ChunkData: []byte("!")}
+	stream = &uploadChunksStream{requests: []*pb.UploadChunkRequest{chunk(5, len(data)), tooBig}}
+	err = server.UploadChunks(stream)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream = &uploadChunksStream{}
+	require.NoError(t, server.UploadChunks(stream))
+	require.Zero(t, stream.response.GetOffset())
+
+	res, err := server.FinalizeUpload(ctx, &pb.FinalizeUploadRequest{UploadId: uploadID, Size: size})
+	require.NoError(t, err)
+	require.Equal(t, size, res.GetSize())
+
+	saved, file, err := imageStore.Open(res.GetId(), 0)
+	require.NoError(t, err)
+	defer file.Close()
+	require.Equal(t, laptop.Id, saved.LaptopID)
+	require.Equal(t, ".png", saved.Type)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+
+	_, err = server.QueryUpload(ctx, &pb.QueryUploadRequest{UploadId: uploadID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// the data is checked against the expected digest once it is all uploaded
+	info.Sha256 = sha256Hex(data[1:])
+	upload, err = server.StartUpload(ctx, &pb.StartUploadRequest{Info: info})
+	require.NoError(t, err)
+	uploadID = upload.GetUploadId()
+	require.NoError(t, server.UploadChunks(&uploadChunksStream{requests: []*pb.UploadChunkRequest{chunk(0, len(data))}}))
+	_, err = server.FinalizeUpload(ctx, &pb.FinalizeUploadRequest{UploadId: uploadID, Size: size})
+	require.Equal(t, codes.DataLoss, status.Code(err))
+
+	disabled := newTestLaptopServer(t, laptopStore, imageStore, NewInMemoryRatingStore())
+	_, err = disabled.StartUpload(ctx, &pb.StartUploadRequest{Info: &pb.ImageInfo{LaptopId: laptop.Id}})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
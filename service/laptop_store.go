@@ -1,30 +1,72 @@
 package service
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/jinzhu/copier"
 	"github.com/moataz-hamed/pb/pb"
+	"google.golang.org/protobuf/proto"
 )
 
 var ErrAlreadyExists = errors.New("Record already exists")
+var ErrNotFound = errors.New("Record not found")
+var ErrVersionMismatch = errors.New("Record version mismatch")
 
+// LaptopStore keeps a version on every laptop which increases monotonically with each change.
+// Save, Update and Patch set the new version on the given laptop, and whenever Update, Patch or Delete
+// receive an expected version other than 0 they fail with ErrVersionMismatch if the stored version differs
 type LaptopStore interface {
 	Save(laptop *pb.Laptop) error
+	// SaveAll saves every laptop in one change or none of them, it returns ErrAlreadyExists if one of their IDs is
+	// already used or repeated
+	SaveAll(laptops []*pb.Laptop) error
 	Find(id string) (*pb.Laptop, error)
+	// Update replaces the stored laptop having the same ID, it returns ErrNotFound if there is none.
+	// laptop.Version is the expected version
+	Update(laptop *pb.Laptop) error
+	// Patch overwrites only the fields named by paths in the stored laptop having the same ID with their values in laptop,
+	// it returns the patched laptop or ErrNotFound if there is none. laptop.Version is the expected version
+	Patch(laptop *pb.Laptop, paths []string) (*pb.Laptop, error)
+	// Delete removes the laptop with the given ID, it returns ErrNotFound if there is none
+	Delete(id string, version uint64) error
+	// Search calls found with every laptop matching the filter in ascending order of ID
 	Search(filter *pb.Filter, found func(laptop *pb.Laptop) error) error
+	// Scan is Search without copying the laptops, they are shared with the store and must not be modified
+	Scan(filter *pb.Filter, found func(laptop *pb.Laptop) error) error
+	// SearchText calls found with every laptop matching the filter whose brand, name, CPU or GPU names contain
+	// each word of the text or a word starting with it, from the most relevant to the least relevant
+	SearchText(text string, filter *pb.Filter, found func(laptop *pb.Laptop, score float64) error) error
+	// Revision returns the revision of the last change
+	Revision() uint64
+	// Watch calls notify with the changes made after the revision, the ones still kept first and then every next one
+	// until cancel is called. notify is called while the store is locked so it must not block. It returns
+	// ErrRevisionExpired if the changes following the revision are not kept anymore
+	Watch(after uint64, notify func(changes *LaptopChanges)) (cancel func(), err error)
 }
 
 type InMemoryLaptopStore struct {
-	mutex sync.RWMutex
-	data  map[string]*pb.Laptop
+	mutex    sync.RWMutex
+	data     map[string]*pb.Laptop
+	ids      []string // IDs of the stored laptops in ascending order
+	indexes  *laptopIndexes
+	text     *textIndex
+	watchers *laptopWatchers
+	revision uint64 // the version given to the last changed laptop
+	// journal, if set, is called with every change before it is applied, the change is dropped if it fails
+	journal func(record *pb.LaptopLogRecord) error
 }
 
 func NewInMemoryLaptopStore() *InMemoryLaptopStore {
 	return &InMemoryLaptopStore{
-		data: make(map[string]*pb.Laptop),
+		data:     make(map[string]*pb.Laptop),
+		indexes:  newLaptopIndexes(),
+		text:     newTextIndex(),
+		watchers: newLaptopWatchers(),
 	}
 }
 
@@ -41,11 +83,150 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 		return fmt.Errorf("Can not copy laptop data:%w", err)
 	}
 
-	store.data[laptop.Id] = other
+	err = store.commit(&pb.LaptopLogRecord{Saved: []*pb.Laptop{other}})
+	if err != nil {
+		return err
+	}
+	laptop.Version = other.Version
+
+	return nil
+}
+
+func (store *InMemoryLaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record := &pb.LaptopLogRecord{}
+	saved := make(map[string]bool, len(laptops))
+	for _, laptop := range laptops {
+		if store.data[laptop.Id] != nil || saved[laptop.Id] {
+			return fmt.Errorf("laptop %s:%w", laptop.Id, ErrAlreadyExists)
+		}
+		saved[laptop.Id] = true
+
+		other := &pb.Laptop{}
+		err := copier.Copy(other, laptop)
+		if err != nil {
+			return fmt.Errorf("Can not copy laptop data:%w", err)
+		}
+		record.Saved = append(record.Saved, other)
+	}
+
+	err := store.commit(record)
+	if err != nil {
+		return err
+	}
+	for _, laptop := range laptops {
+		laptop.Version = record.Revision
+	}
+
+	return nil
+}
+
+func (store *InMemoryLaptopStore) Update(laptop *pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	err := checkVersion(store.data[laptop.Id], laptop.Version)
+	if err != nil {
+		return err
+	}
+
+	other := &pb.Laptop{}
+	err = copier.Copy(other, laptop)
+	if err != nil {
+		return fmt.Errorf("Can not copy laptop data:%w", err)
+	}
+
+	err = store.commit(&pb.LaptopLogRecord{Saved: []*pb.Laptop{other}})
+	if err != nil {
+		return err
+	}
+	laptop.Version = other.Version
+
+	return nil
+}
+
+func (store *InMemoryLaptopStore) Patch(laptop *pb.Laptop, paths []string) (*pb.Laptop, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	stored := store.data[laptop.Id]
+	err := checkVersion(stored, laptop.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// clone the nested messages as well, so the patch never touches laptops handed out before
+	other := proto.Clone(stored).(*pb.Laptop)
+	applyFieldMask(other.ProtoReflect(), proto.Clone(laptop).ProtoReflect(), paths)
+
+	err = store.commit(&pb.LaptopLogRecord{Saved: []*pb.Laptop{other}})
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Clone(other).(*pb.Laptop), nil
+}
+
+func (store *InMemoryLaptopStore) Delete(id string, version uint64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	err := checkVersion(store.data[id], version)
+	if err != nil {
+		return err
+	}
+
+	return store.commit(&pb.LaptopLogRecord{DeletedIds: []string{id}})
+}
+
+// commit gives the saved laptops of the record the next version, writes the record to the journal,
+// applies it and notifies the watchers. The mutex must be locked by the caller
+func (store *InMemoryLaptopStore) commit(record *pb.LaptopLogRecord) error {
+	record.Revision = store.revision + 1
+	for _, laptop := range record.Saved {
+		laptop.Version = record.Revision
+	}
+
+	if store.journal != nil {
+		err := store.journal(record)
+		if err != nil {
+			return fmt.Errorf("Can not write change to the journal:%w", err)
+		}
+	}
 
+	changes := store.changes(record)
+	store.apply(record)
+	store.watchers.publish(changes)
 	return nil
 }
 
+// apply changes the stored laptops as described by the record, the mutex must be locked by the caller
+func (store *InMemoryLaptopStore) apply(record *pb.LaptopLogRecord) {
+	for _, laptop := range record.GetSaved() {
+		old := store.data[laptop.Id]
+		if old == nil {
+			i, _ := slices.BinarySearch(store.ids, laptop.Id)
+			store.ids = slices.Insert(store.ids, i, laptop.Id)
+		}
+		store.indexes.update(old, laptop)
+		store.text.update(old, laptop)
+		store.data[laptop.Id] = laptop
+	}
+
+	for _, id := range record.GetDeletedIds() {
+		i, found := slices.BinarySearch(store.ids, id)
+		if found {
+			store.ids = slices.Delete(store.ids, i, i+1)
+		}
+		store.indexes.update(store.data[id], nil)
+		store.text.update(store.data[id], nil)
+		delete(store.data, id)
+	}
+
+	if record.GetRevision() > store.revision {
+		store.revision = record.GetRevision()
+	}
+}
+
 func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
@@ -65,28 +246,106 @@ func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 }
 
 func (store *InMemoryLaptopStore) Search(filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	return store.search(filter, true, found)
+}
+
+func (store *InMemoryLaptopStore) Scan(filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	for _, laptop := range store.matches(filter, true) {
+		err := found(laptop)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// search narrows the laptops to check with the secondary indexes if useIndexes is set,
+// otherwise it scans all of them
+func (store *InMemoryLaptopStore) search(filter *pb.Filter, useIndexes bool, found func(laptop *pb.Laptop) error) error {
+	// stored laptops are replaced but never changed in place,
+	// so they are cloned and handed out without blocking the writers
+	for _, laptop := range store.matches(filter, useIndexes) {
+		err := found(proto.Clone(laptop).(*pb.Laptop))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches returns the stored laptops matching the filter in ascending order of ID
+func (store *InMemoryLaptopStore) matches(filter *pb.Filter, useIndexes bool) []*pb.Laptop {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
-	for _, laptop := range store.data {
+	ids, indexed := store.indexes.plan(filter, len(store.ids))
+	if !useIndexes || !indexed {
+		ids = store.ids
+	}
+
+	var matches []*pb.Laptop
+	for _, id := range ids {
+		laptop := store.data[id]
+		if isQualified(filter, laptop) {
+			matches = append(matches, laptop)
+		}
+	}
+	return matches
+}
+
+func (store *InMemoryLaptopStore) SearchText(text string, filter *pb.Filter, found func(laptop *pb.Laptop, score float64) error) error {
+	type scoredLaptop struct {
+		laptop *pb.Laptop
+		score  float64
+	}
+
+	store.mutex.RLock()
+	var matches []scoredLaptop
+	for id, score := range store.text.search(text) {
+		laptop := store.data[id]
 		if isQualified(filter, laptop) {
-			other := &pb.Laptop{}
-
-			err := copier.Copy(other, laptop)
-			if err != nil {
-				return err
-			}
-			err = found(other)
-			if err != nil {
-				return err
-			}
+			matches = append(matches, scoredLaptop{laptop: laptop, score: score})
+		}
+	}
+	store.mutex.RUnlock()
+
+	slices.SortFunc(matches, func(a, b scoredLaptop) int {
+		if result := cmp.Compare(b.score, a.score); result != 0 {
+			return result
 		}
+		return cmp.Compare(a.laptop.GetId(), b.laptop.GetId())
+	})
+
+	for _, match := range matches {
+		err := found(proto.Clone(match.laptop).(*pb.Laptop), match.score)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVersion returns ErrNotFound if there is no stored laptop, or ErrVersionMismatch if the expected version
+// is set and differs from the stored one. Every LaptopStore must call it before changing a laptop
+func checkVersion(stored *pb.Laptop, expected uint64) error {
+	if stored == nil {
+		return ErrNotFound
+	}
+
+	if expected != 0 && expected != stored.GetVersion() {
+		return fmt.Errorf("%w: expected version %d but stored version is %d", ErrVersionMismatch, expected, stored.GetVersion())
 	}
+
 	return nil
 }
 
+// isQualified accepts every laptop if there is no filter at all
 func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
-	if laptop.GetPriceUsd() > filter.GetMaxPriceUsd() {
+	if filter == nil {
+		return true
+	}
+
+	if filter.GetMaxPriceUsd() > 0 && laptop.GetPriceUsd() > filter.GetMaxPriceUsd() {
 		return false
 	}
 
@@ -102,9 +361,108 @@ func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
 		return false
 	}
 
+	if len(filter.GetBrands()) > 0 && !slices.ContainsFunc(filter.GetBrands(), func(brand string) bool {
+		return strings.EqualFold(brand, laptop.GetBrand())
+	}) {
+		return false
+	}
+
+	if filter.GetMinGpuMemory() != nil && !slices.ContainsFunc(laptop.GetGpu(), func(gpu *pb.GPU) bool {
+		return toBit(gpu.GetMemory()) >= toBit(filter.GetMinGpuMemory())
+	}) {
+		return false
+	}
+
+	return isScreenQualified(filter, laptop.GetScreen()) &&
+		isStorageQualified(filter, laptop.GetStorages()) &&
+		isKeyboardQualified(filter, laptop.GetKeyboard()) &&
+		isWeightQualified(filter, laptop) &&
+		isReleaseYearQualified(filter, laptop.GetReleaseYear())
+}
+
+func isScreenQualified(filter *pb.Filter, screen *pb.Screen) bool {
+	if filter.GetMinScreenSizeInch() > 0 && screen.GetSizeInch() < filter.GetMinScreenSizeInch() {
+		return false
+	}
+
+	if filter.GetMaxScreenSizeInch() > 0 && screen.GetSizeInch() > filter.GetMaxScreenSizeInch() {
+		return false
+	}
+
+	if screen.GetResolution().GetWidth() < filter.GetMinResolution().GetWidth() ||
+		screen.GetResolution().GetHeight() < filter.GetMinResolution().GetHeight() {
+		return false
+	}
+
+	if filter.GetScreenPanel() != pb.Screen_UNKNOWN && screen.GetPanel() != filter.GetScreenPanel() {
+		return false
+	}
+
 	return true
 }
 
+func isStorageQualified(filter *pb.Filter, storages []*pb.Storage) bool {
+	if filter.GetMinSsdStorage() == nil {
+		return true
+	}
+
+	total := 0
+	for _, storage := range storages {
+		if storage.GetDriver() == pb.Storage_SSD {
+			total += toBit(storage.GetMemory())
+		}
+	}
+	return total >= toBit(filter.GetMinSsdStorage())
+}
+
+func isKeyboardQualified(filter *pb.Filter, keyboard *pb.Keyboard) bool {
+	if filter.GetKeyboardLayout() != pb.Keyboard_UNKNOWN && keyboard.GetLayout() != filter.GetKeyboardLayout() {
+		return false
+	}
+
+	if filter.GetKeyboardBacklit() != nil && keyboard.GetBacklit() != filter.GetKeyboardBacklit().GetValue() {
+		return false
+	}
+
+	return true
+}
+
+// isWeightQualified excludes the laptops without a weight when a maximum weight is set
+func isWeightQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
+	if filter.GetMaxWeightKg() <= 0 {
+		return true
+	}
+
+	weight, ok := toKg(laptop)
+	return ok && weight <= filter.GetMaxWeightKg()
+}
+
+func isReleaseYearQualified(filter *pb.Filter, year uint32) bool {
+	if filter.GetMinReleaseYear() > 0 && year < filter.GetMinReleaseYear() {
+		return false
+	}
+
+	if filter.GetMaxReleaseYear() > 0 && year > filter.GetMaxReleaseYear() {
+		return false
+	}
+
+	return true
+}
+
+const kgPerLb = 0.45359237
+
+// toKg returns the weight of the laptop in kilograms, or false if it has no weight
+func toKg(laptop *pb.Laptop) (float64, bool) {
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		return weight.WeightKg, true
+	case *pb.Laptop_WeightLb:
+		return weight.WeightLb * kgPerLb, true
+	default:
+		return 0, false
+	}
+}
+
 func toBit(memory *pb.Memory) int {
 	value := int(memory.GetValue())
 	unit := memory.GetUnit()
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"github.com/moataz-hamed/pb/pb"
+	"github.com/moataz-hamed/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestInMemoryLaptopStoreVersion(t *testing.T) {
+	t.Parallel()
+
+	testLaptopStoreVersion(t, NewInMemoryLaptopStore())
+}
+
+// testLaptopStoreVersion checks the optimistic concurrency rules every LaptopStore has to follow
+func testLaptopStoreVersion(t *testing.T, store LaptopStore) {
+	laptop := sample.NewLaptop()
+	err := store.Save(laptop)
+	require.NoError(t, err)
+	created := laptop.Version
+	require.NotZero(t, created)
+
+	found, err := store.Find(laptop.Id)
+	require.NoError(t, err)
+	require.Equal(t, created, found.Version)
+
+	laptop.PriceUsd = 1234
+	err = store.Update(laptop)
+	require.NoError(t, err)
+	require.Greater(t, laptop.Version, created)
+
+	stale := sample.NewLaptop()
+	stale.Id = laptop.Id
+	stale.Version = created
+	err = store.Update(stale)
+	require.ErrorIs(t, err, ErrVersionMismatch)
+
+	_, err = store.Patch(stale, []string{"price_usd"})
+	require.ErrorIs(t, err, ErrVersionMismatch)
+
+	err = store.Delete(laptop.Id, created)
+	require.ErrorIs(t, err, ErrVersionMismatch)
+
+	stale.Version = 0
+	patched, err := store.Patch(stale, []string{"price_usd"})
+	require.NoError(t, err)
+	require.Greater(t, patched.Version, laptop.Version)
+	require.Equal(t, stale.PriceUsd, patched.PriceUsd)
+
+	err = store.Delete(laptop.Id, patched.Version)
+	require.NoError(t, err)
+
+	err = store.Delete(laptop.Id, 0)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestIsQualified(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.Brand = "DELL"
+	laptop.PriceUsd = 1500
+	laptop.Weight = &pb.Laptop_WeightLb{WeightLb: 4}
+	laptop.ReleaseYear = 2021
+	laptop.Keyboard = &pb.Keyboard{Layout: pb.Keyboard_QWERTY, Backlit: true}
+	laptop.Screen.Panel = pb.Screen_IPS
+	laptop.Storages = []*pb.Storage{
+		{Driver: pb.Storage_SSD, Memory: &pb.Memory{Value: 512, Unit: pb.Memory_GIGABYTE}},
+		{Driver: pb.Storage_SSD, Memory: &pb.Memory{Value: 512, Unit: pb.Memory_GIGABYTE}},
+		{Driver: pb.Storage_HDD, Memory: &pb.Memory{Value: 2, Unit: pb.Memory_TERABYTE}},
+	}
+
+	testCases := []struct {
+		name      string
+		filter    *pb.Filter
+		qualified bool
+	}{
+		{"unset criteria", &pb.Filter{}, true},
+		{"max price", &pb.Filter{MaxPriceUsd: 2000}, true},
+		{"too expensive", &pb.Filter{MaxPriceUsd: 1000}, false},
+		{"brand", &pb.Filter{Brands: []string{"Apple", "dell"}}, true},
+		{"other brand", &pb.Filter{Brands: []string{"Apple"}}, false},
+		{"ssd storage", &pb.Filter{MinSsdStorage: &pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE}}, true},
+		{"too little ssd storage", &pb.Filter{MinSsdStorage: &pb.Memory{Value: 2, Unit: pb.Memory_TERABYTE}}, false},
+		{"weight in pounds", &pb.Filter{MaxWeightKg: 2}, true},
+		{"too heavy", &pb.Filter{MaxWeightKg: 1.5}, false},
+		{"keyboard", &pb.Filter{KeyboardLayout: pb.Keyboard_QWERTY, KeyboardBacklit: wrapperspb.Bool(true)}, true},
+		{"not backlit", &pb.Filter{KeyboardBacklit: wrapperspb.Bool(false)}, false},
+		{"panel", &pb.Filter{ScreenPanel: pb.Screen_OLED}, false},
+		{"release years", &pb.Filter{MinReleaseYear: 2020, MaxReleaseYear: 2021}, true},
+		{"too old", &pb.Filter{MinReleaseYear: 2022}, false},
+		{"gpu memory", &pb.Filter{MinGpuMemory: &pb.Memory{Value: 64, Unit: pb.Memory_GIGABYTE}}, false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.qualified, isQualified(tc.filter, laptop), tc.name)
+	}
+}
+
+func TestInMemoryLaptopStoreSearchIndexed(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, store.Save(sample.NewLaptop()))
+	}
+
+	// changed and deleted laptops must leave the indexes too
+	laptop := sample.NewLaptop()
+	laptop.PriceUsd = 2000
+	require.NoError(t, store.Save(laptop))
+	laptop.PriceUsd = 510
+	require.NoError(t, store.Update(laptop))
+	require.NoError(t, store.Delete(store.ids[0], 0))
+
+	// the filters never compare NaN values out, the indexes must find them like a scan does
+	for i := 0; i < 3; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = math.NaN()
+		laptop.Cpu.MinGhz = math.NaN()
+		require.NoError(t, store.Save(laptop))
+	}
+	laptop.PriceUsd = math.NaN()
+	require.NoError(t, store.Update(laptop))
+	laptop.PriceUsd = 520
+	require.NoError(t, store.Update(laptop))
+
+	filters := []*pb.Filter{
+		{MaxPriceUsd: 600},
+		{MaxPriceUsd: 2000, MinCpuCores: 8},
+		{MaxPriceUsd: 1000, MinCpuGhz: 3, MinRam: &pb.Memory{Value: 32, Unit: pb.Memory_GIGABYTE}},
+		{MaxPriceUsd: 100},
+		{MaxPriceUsd: 3000, MinCpuGhz: 3.4},
+		{MinCpuCores: 8},
+	}
+
+	for _, filter := range filters {
+		var scanned, indexed []string
+		err := store.search(filter, false, func(laptop *pb.Laptop) error {
+			scanned = append(scanned, laptop.GetId())
+			return nil
+		})
+		require.NoError(t, err)
+
+		err = store.search(filter, true, func(laptop *pb.Laptop) error {
+			indexed = append(indexed, laptop.GetId())
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, scanned, indexed)
+	}
+
+	_, planned := store.indexes.plan(&pb.Filter{MaxPriceUsd: 100}, len(store.ids))
+	require.True(t, planned)
+
+	// an unset max price does not exclude any laptop, so the price index can't narrow the search
+	_, planned = store.indexes.plan(&pb.Filter{Brands: []string{"Apple"}}, len(store.ids))
+	require.False(t, planned)
+}
+
+func TestInMemoryLaptopStoreScan(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, store.Save(sample.NewLaptop()))
+	}
+
+	filter := &pb.Filter{MaxPriceUsd: 1500}
+	var searched []*pb.Laptop
+	require.NoError(t, store.Search(filter, func(laptop *pb.Laptop) error {
+		searched = append(searched, laptop)
+		return nil
+	}))
+
+	// the same laptops are found, without being copied
+	var scanned []*pb.Laptop
+	require.NoError(t, store.Scan(filter, func(laptop *pb.Laptop) error {
+		require.Same(t, store.data[laptop.Id], laptop)
+		scanned = append(scanned, laptop)
+		return nil
+	}))
+	require.Len(t, scanned, len(searched))
+	for i := range searched {
+		require.NotSame(t, searched[i], scanned[i])
+		require.True(t, proto.Equal(searched[i], scanned[i]))
+	}
+}
+
+func BenchmarkInMemoryLaptopStoreSearch(b *testing.B) {
+	store := NewInMemoryLaptopStore()
+	for i := 0; i < 100000; i++ {
+		require.NoError(b, store.Save(sample.NewLaptop()))
+	}
+
+	// about 2% of the laptops are cheap enough
+	filter := &pb.Filter{MaxPriceUsd: 530}
+
+	for _, useIndexes := range []bool{false, true} {
+		name := "scan"
+		if useIndexes {
+			name = "indexed"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := store.search(filter, useIndexes, func(laptop *pb.Laptop) error {
+					return nil
+				})
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func TestInMemoryLaptopStoreSearchText(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+
+	newLaptop := func(brand, name, gpu string, price float64) *pb.Laptop {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = price
+		laptop.Brand = brand
+		laptop.Name = name
+		laptop.Cpu.Name = "Core i7"
+		laptop.Gpu = []*pb.GPU{{Name: gpu}}
+		require.NoError(t, store.Save(laptop))
+		return laptop
+	}
+
+	x1 := newLaptop("Lenovo", "Thinkpad X1", "RTX 2070", 1500)
+	x13 := newLaptop("Lenovo", "Thinkpad X13", "RTX 3060", 1800)
+	gaming := newLaptop("Dell", "Alienware", "RTX 3060", 1200)
+	newLaptop("Apple", "Macbook Pro", "Apple M2", 2000)
+
+	search := func(text string, filter *pb.Filter) []string {
+		var ids []string
+		err := store.SearchText(text, filter, func(laptop *pb.Laptop, score float64) error {
+			ids = append(ids, laptop.GetId())
+			return nil
+		})
+		require.NoError(t, err)
+		return ids
+	}
+
+	// the exact word ranks above the one only starting with it
+	require.Equal(t, []string{x1.Id, x13.Id}, search("THINKPAD x1", nil))
+	require.ElementsMatch(t, []string{x13.Id, gaming.Id}, search("rtx 3060", nil))
+	require.Empty(t, search("thinkpad 3060 alienware", nil))
+	require.Empty(t, search("--", nil))
+
+	require.Equal(t, []string{gaming.Id}, search("rtx 3060", &pb.Filter{MaxPriceUsd: 1500}))
+
+	// the index follows the changes of the laptops
+	x13.Name = "Yoga"
+	require.NoError(t, store.Update(x13))
+	require.Equal(t, []string{x1.Id}, search("thinkpad", nil))
+
+	require.NoError(t, store.Delete(gaming.Id, 0))
+	require.Empty(t, search("alien", nil))
+}
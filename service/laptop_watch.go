@@ -0,0 +1,243 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/moataz-hamed/pb/pb"
+)
+
+// ErrRevisionExpired is returned when the changes following a revision are not kept anymore
+var ErrRevisionExpired = errors.New("changes after this revision are not available anymore")
+
+// minimum number of commits kept by the store to resume the watchers, it keeps up to twice as many
+// so the history is only copied once in a while
+const watchHistorySize = 1024
+
+// LaptopChange is the change of one laptop, Old is nil if the laptop is created and New is nil if it is deleted.
+// The laptops are shared with the store and must not be modified
+type LaptopChange struct {
+	Old *pb.Laptop
+	New *pb.Laptop
+}
+
+// LaptopChanges are the changes of the laptops made by one commit to the store
+type LaptopChanges struct {
+	Revision uint64
+	Changes  []LaptopChange
+}
+
+// laptopWatchers keeps the recent commits of the store and the functions notified of the next ones
+type laptopWatchers struct {
+	history []*LaptopChanges
+	notify  map[int]func(changes *LaptopChanges)
+	nextID  int
+}
+
+func newLaptopWatchers() *laptopWatchers {
+	return &laptopWatchers{notify: make(map[int]func(changes *LaptopChanges))}
+}
+
+// changes describes what the record will change in the stored laptops, it must be called before the record is applied
+func (store *InMemoryLaptopStore) changes(record *pb.LaptopLogRecord) *LaptopChanges {
+	changes := &LaptopChanges{Revision: record.GetRevision()}
+	for _, laptop := range record.GetSaved() {
+		changes.Changes = append(changes.Changes, LaptopChange{Old: store.data[laptop.GetId()], New: laptop})
+	}
+	for _, id := range record.GetDeletedIds() {
+		if old := store.data[id]; old != nil {
+			changes.Changes = append(changes.Changes, LaptopChange{Old: old})
+		}
+	}
+	return changes
+}
+
+// publish keeps the changes in the history and notifies the watchers
+func (watchers *laptopWatchers) publish(changes *LaptopChanges) {
+	watchers.history = append(watchers.history, changes)
+	if len(watchers.history) >= 2*watchHistorySize {
+		watchers.history = slices.Clone(watchers.history[len(watchers.history)-watchHistorySize:])
+	}
+
+	for _, notify := range watchers.notify {
+		notify(changes)
+	}
+}
+
+func (store *InMemoryLaptopStore) Revision() uint64 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.revision
+}
+
+func (store *InMemoryLaptopStore) Watch(after uint64, notify func(changes *LaptopChanges)) (func(), error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// the history holds every commit since its first one
+	history := store.watchers.history
+	covered := after == store.revision ||
+		(len(history) > 0 && after+1 >= history[0].Revision && after < store.revision)
+	if !covered {
+		return nil, ErrRevisionExpired
+	}
+
+	for _, changes := range history {
+		if changes.Revision > after {
+			notify(changes)
+		}
+	}
+
+	id := store.watchers.nextID
+	store.watchers.nextID++
+	store.watchers.notify[id] = notify
+
+	cancel := func() {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		delete(store.watchers.notify, id)
+	}
+	return cancel, nil
+}
+
+// number of commits matching its filter a watcher may lag behind before its stream is ended
+const watchBufferSize = 256
+
+// minimum time between the progress events of a watcher
+const watchProgressInterval = time.Second
+
+// progressEvent tells the watcher it can resume after the revision, when the changes matched nothing
+func progressEvent(revision uint64) *pb.WatchLaptopsResponse {
+	return &pb.WatchLaptopsResponse{
+		Type:        pb.WatchLaptopsResponse_PROGRESS,
+		Revision:    revision,
+		ResumeToken: encodeResumeToken(revision),
+	}
+}
+
+// watchQueue holds the events of the commits a watcher has not been sent yet. Only the commits
+// matching its filter are counted, the following ones which match nothing are merged in a progress event
+type watchQueue struct {
+	mutex      sync.Mutex
+	filter     *pb.Filter
+	items      [][]*pb.WatchLaptopsResponse // events of a commit, or a single progress event
+	commits    int                          // number of items which are not progress events
+	overflowed bool
+	ready      chan struct{} // signaled once items are pushed
+}
+
+func newWatchQueue(filter *pb.Filter) *watchQueue {
+	return &watchQueue{filter: filter, ready: make(chan struct{}, 1)}
+}
+
+// push queues the events of the changes, it never waits so the store is not held by a slow watcher.
+// The changes are dropped once the queue overflows
+func (queue *watchQueue) push(changes *LaptopChanges) {
+	events := watchEvents(queue.filter, changes)
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.overflowed {
+		return
+	}
+
+	if len(events) == 0 {
+		last := len(queue.items) - 1
+		if last >= 0 && queue.items[last][0].Type == pb.WatchLaptopsResponse_PROGRESS {
+			queue.items[last][0] = progressEvent(changes.Revision)
+		} else {
+			queue.items = append(queue.items, []*pb.WatchLaptopsResponse{progressEvent(changes.Revision)})
+		}
+	} else if queue.commits == watchBufferSize {
+		queue.overflowed = true
+	} else {
+		queue.items = append(queue.items, events)
+		queue.commits++
+	}
+
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop takes the queued events and tells whether the changes following them were dropped
+func (queue *watchQueue) pop() ([][]*pb.WatchLaptopsResponse, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	items := queue.items
+	queue.items = nil
+	queue.commits = 0
+	return items, queue.overflowed
+}
+
+func encodeResumeToken(revision uint64) string {
+	return strconv.FormatUint(revision, 10)
+}
+
+func decodeResumeToken(token string) (uint64, error) {
+	revision, err := strconv.ParseUint(token, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid resume token %q", token)
+	}
+	return revision, nil
+}
+
+// watchEvents returns the events sent for the changes to the laptops matching the filter before or after them,
+// the last one holds the resume token of the revision
+func watchEvents(filter *pb.Filter, changes *LaptopChanges) []*pb.WatchLaptopsResponse {
+	var events []*pb.WatchLaptopsResponse
+	for _, change := range changes.Changes {
+		event := &pb.WatchLaptopsResponse{Laptop: change.New, Revision: changes.Revision}
+		switch {
+		case change.New == nil:
+			event.Type = pb.WatchLaptopsResponse_DELETED
+			event.Laptop = change.Old
+		case change.Old == nil:
+			event.Type = pb.WatchLaptopsResponse_CREATED
+		default:
+			event.Type = pb.WatchLaptopsResponse_UPDATED
+		}
+
+		if (change.Old != nil && isQualified(filter, change.Old)) || (change.New != nil && isQualified(filter, change.New)) {
+			events = append(events, event)
+		}
+	}
+
+	if len(events) > 0 {
+		events[len(events)-1].ResumeToken = encodeResumeToken(changes.Revision)
+	}
+	return events
+}
+
+// sendWatchSnapshot sends a RESET event and the laptops matching the filter, it returns the revision
+// the next changes must follow
+func (server *LaptopServer) sendWatchSnapshot(filter *pb.Filter, stream pb.LaptopService_WatchLaptopsServer) (uint64, error) {
+	// the laptops may be found at a later revision, the changes sent again afterwards only repeat their state
+	revision := server.LaptopStore.Revision()
+
+	events := []*pb.WatchLaptopsResponse{{Type: pb.WatchLaptopsResponse_RESET, Revision: revision}}
+	err := server.LaptopStore.Search(filter, func(laptop *pb.Laptop) error {
+		events = append(events, &pb.WatchLaptopsResponse{Type: pb.WatchLaptopsResponse_CREATED, Laptop: laptop, Revision: laptop.GetVersion()})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// the stream can only be resumed once the whole snapshot is received
+	events[len(events)-1].ResumeToken = encodeResumeToken(revision)
+	for _, event := range events {
+		err := stream.Send(event)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return revision, nil
+}
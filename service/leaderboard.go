@@ -0,0 +1,150 @@
+package service
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// default number of votes a laptop needs before its own average outweighs the prior mean
+const defaultMinVotes = 5
+
+// leaderboard keeps the rated laptops ordered by their Bayesian average, the average of their scores
+// with minVotes more votes of the prior mean. The prior is fixed so one vote only moves its own laptop
+type leaderboard struct {
+	mutex       sync.RWMutex
+	ratingStore RatingStore
+	priorMean   float64
+	minVotes    float64
+	entries     []leaderboardEntry // the best laptops first
+	byLaptop    map[string]leaderboardEntry
+	stopWatch   func()
+}
+
+type leaderboardEntry struct {
+	laptopID string
+	score    float64 // Bayesian average
+	rating   *Rating
+}
+
+// compareLeaderboardEntries orders the entries from the best to the worst score, then by most votes and by ID
+func compareLeaderboardEntries(a, b leaderboardEntry) int {
+	if result := cmp.Compare(b.score, a.score); result != 0 {
+		return result
+	}
+	if result := cmp.Compare(b.rating.Count, a.rating.Count); result != 0 {
+		return result
+	}
+	return cmp.Compare(a.laptopID, b.laptopID)
+}
+
+// newLeaderboard ranks the stored laptops already rated in the rating store. The ratings of the deleted laptops
+// are kept, so the laptops leave the board once they are deleted from the laptop store
+func newLeaderboard(laptopStore LaptopStore, ratingStore RatingStore, priorMean float64, minVotes uint32) (*leaderboard, error) {
+	board := &leaderboard{
+		ratingStore: ratingStore,
+		priorMean:   priorMean,
+		minVotes:    float64(minVotes),
+		byLaptop:    make(map[string]leaderboardEntry),
+	}
+
+	var err error
+	board.stopWatch, err = laptopStore.Watch(laptopStore.Revision(), board.forget)
+	for errors.Is(err, ErrRevisionExpired) {
+		// a burst of commits since the revision was read
+		board.stopWatch, err = laptopStore.Watch(laptopStore.Revision(), board.forget)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot watch laptops:%w", err)
+	}
+
+	err = ratingStore.List(func(laptopID string, rating *Rating) error {
+		laptop, err := laptopStore.Find(laptopID)
+		if err != nil || laptop == nil {
+			return err
+		}
+
+		board.mutex.Lock()
+		defer board.mutex.Unlock()
+		board.set(laptopID, rating)
+		return nil
+	})
+	if err != nil {
+		board.close()
+		return nil, fmt.Errorf("cannot list ratings:%w", err)
+	}
+	return board, nil
+}
+
+// close stops watching the laptop store, the board no longer forgets the deleted laptops
+func (board *leaderboard) close() {
+	board.stopWatch()
+}
+
+// forget removes the laptops deleted by the changes, it is notified by the laptop store
+func (board *leaderboard) forget(changes *LaptopChanges) {
+	board.mutex.Lock()
+	defer board.mutex.Unlock()
+
+	for _, change := range changes.Changes {
+		if change.New == nil {
+			board.set(change.Old.GetId(), nil)
+		}
+	}
+}
+
+func (board *leaderboard) bayesianAverage(rating *Rating) float64 {
+	return (rating.Sum + board.minVotes*board.priorMean) / (float64(rating.Count) + board.minVotes)
+}
+
+// refresh reads the rating of the laptop again once it changed, reading it under the lock
+// keeps the latest rating when several votes for the laptop are refreshed concurrently
+func (board *leaderboard) refresh(laptopID string) error {
+	board.mutex.Lock()
+	defer board.mutex.Unlock()
+
+	rating, err := board.ratingStore.Find(laptopID)
+	if err != nil {
+		return fmt.Errorf("cannot find rating:%w", err)
+	}
+	board.set(laptopID, rating)
+	return nil
+}
+
+// set moves the laptop to the place of its rating, a laptop without votes leaves the board.
+// The mutex must be locked by the caller
+func (board *leaderboard) set(laptopID string, rating *Rating) {
+	if old, found := board.byLaptop[laptopID]; found {
+		i, found := slices.BinarySearchFunc(board.entries, old, compareLeaderboardEntries)
+		if found {
+			board.entries = slices.Delete(board.entries, i, i+1)
+		}
+		delete(board.byLaptop, laptopID)
+	}
+
+	if rating == nil || rating.Count == 0 {
+		return
+	}
+
+	entry := leaderboardEntry{laptopID: laptopID, score: board.bayesianAverage(rating), rating: rating}
+	i, _ := slices.BinarySearchFunc(board.entries, entry, compareLeaderboardEntries)
+	board.entries = slices.Insert(board.entries, i, entry)
+	board.byLaptop[laptopID] = entry
+}
+
+// top calls found with the laptops from the best to the worst until it returns an error
+func (board *leaderboard) top(found func(entry leaderboardEntry) error) error {
+	board.mutex.RLock()
+	entries := slices.Clone(board.entries)
+	board.mutex.RUnlock()
+
+	for _, entry := range entries {
+		err := found(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
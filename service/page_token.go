@@ -0,0 +1,78 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var errInvalidPageToken = errors.New("invalid page token")
+
+// pageToken is where the next page of a search starts, it is bound to the query it was made for
+type pageToken struct {
+	After searchCursor `json:"after"`
+	Query []byte       `json:"query"`
+}
+
+// pageTokenCodec turns page tokens into opaque strings signed with a HMAC,
+// so clients can't forge them or change their position
+type pageTokenCodec struct {
+	key []byte
+}
+
+func (codec pageTokenCodec) encode(token *pageToken) (string, error) {
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal page token:%w", err)
+	}
+
+	data := append(payload, codec.sign(payload)...)
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decode verifies the signature of the page token and that it was made for the same query
+func (codec pageTokenCodec) decode(value string, query []byte) (*pageToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) < sha256.Size {
+		return nil, errInvalidPageToken
+	}
+
+	payload := data[:len(data)-sha256.Size]
+	if !hmac.Equal(codec.sign(payload), data[len(payload):]) {
+		return nil, errInvalidPageToken
+	}
+
+	token := &pageToken{}
+	err = json.Unmarshal(payload, token)
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+
+	if !hmac.Equal(token.Query, query) {
+		return nil, fmt.Errorf("%w: it was made for another query", errInvalidPageToken)
+	}
+
+	return token, nil
+}
+
+func (codec pageTokenCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, codec.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// queryDigest identifies a query, so a page token made for it can't be used with another one
+func queryDigest(query proto.Message) ([]byte, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal query:%w", err)
+	}
+
+	digest := sha256.Sum256(data)
+	return digest[:], nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"math"
+)
+
+// RatingScale is the range of the scores users can give to the laptops, by whole steps or half steps
+type RatingScale struct {
+	Min       float64
+	Max       float64
+	HalfSteps bool
+}
+
+// DefaultRatingScale accepts whole scores from 1 to 10
+var DefaultRatingScale = RatingScale{Min: 1, Max: 10}
+
+// Validate returns an error if the scale can't hold any score
+func (scale RatingScale) Validate() error {
+	if math.IsNaN(scale.Min) || math.IsInf(scale.Min, 0) || math.IsNaN(scale.Max) || math.IsInf(scale.Max, 0) {
+		return fmt.Errorf("rating scale bounds must be finite")
+	}
+	if scale.Min > scale.Max {
+		return fmt.Errorf("rating scale min %v is greater than max %v", scale.Min, scale.Max)
+	}
+	return nil
+}
+
+// check returns an error if the score is not finite, out of the scale or between two steps
+func (scale RatingScale) check(score float64) error {
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return fmt.Errorf("score %v is not a finite number", score)
+	}
+	if score < scale.Min || score > scale.Max {
+		return fmt.Errorf("score %v is out of the range %v to %v", score, scale.Min, scale.Max)
+	}
+
+	steps := score - scale.Min
+	if scale.HalfSteps {
+		steps *= 2
+	}
+	if steps != math.Trunc(steps) {
+		if scale.HalfSteps {
+			return fmt.Errorf("score %v is not a multiple of 0.5 from %v", score, scale.Min)
+		}
+		return fmt.Errorf("score %v is not a whole step from %v", score, scale.Min)
+	}
+	return nil
+}
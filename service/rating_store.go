@@ -1,42 +1,206 @@
 package service
 
-import "sync"
+import (
+	"cmp"
+	"math"
+	"slices"
+	"sync"
+)
 
 type RatingStore interface {
-	Add(laptopID string, score float64) (*Rating, error)
+	// Rate sets the score of the user for the laptop, replacing the one they gave before if any, and tells
+	// whether it is their first score. Stores which can see the laptops check in the same transaction that
+	// the laptop still exists and return ErrNotFound otherwise
+	Rate(laptopID string, username string, score float64) (rating *Rating, created bool, err error)
+	// Retract removes the score of the user for the laptop, it returns ErrNotFound if they have not rated it
+	Retract(laptopID string, username string) (*Rating, error)
+	// Find returns the rating of the laptop, or nil if it has not been rated yet
+	Find(laptopID string) (*Rating, error)
+	// List calls found with the rating of every laptop which has been rated
+	List(found func(laptopID string, rating *Rating) error) error
 }
 
+// Rating sums up the scores of a laptop, every field is updated as the scores change
 type Rating struct {
+	Count      uint32
+	Sum        float64
+	SumSquares float64
+	Histogram  []RatingBucket // in ascending order of score
+}
+
+// RatingBucket is the number of users who gave a score
+type RatingBucket struct {
+	Score float64
 	Count uint32
-	Sum   float64
+}
+
+// replace swaps the previous score of a user for their new one, previous is nil if they had no score
+// and score is nil if they retract it
+func (rating *Rating) replace(previous *float64, score *float64) {
+	if previous != nil {
+		rating.Count--
+		rating.Sum -= *previous
+		rating.SumSquares -= *previous * *previous
+		rating.count(*previous, -1)
+	}
+	if score != nil {
+		rating.Count++
+		rating.Sum += *score
+		rating.SumSquares += *score * *score
+		rating.count(*score, 1)
+	}
+	if rating.Count == 0 {
+		// no rounding error is left behind once the last score is removed
+		rating.Sum = 0
+		rating.SumSquares = 0
+	}
+}
+
+// count changes the number of users who gave the score, the buckets left empty are removed
+func (rating *Rating) count(score float64, delta int) {
+	i, found := slices.BinarySearchFunc(rating.Histogram, score, func(bucket RatingBucket, score float64) int {
+		return cmp.Compare(bucket.Score, score)
+	})
+	if !found {
+		rating.Histogram = slices.Insert(rating.Histogram, i, RatingBucket{Score: score})
+	}
+
+	rating.Histogram[i].Count = uint32(int(rating.Histogram[i].Count) + delta)
+	if rating.Histogram[i].Count == 0 {
+		rating.Histogram = slices.Delete(rating.Histogram, i, i+1)
+	}
+}
+
+func (rating *Rating) Mean() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+	return rating.Sum / float64(rating.Count)
+}
+
+// Median is the middle score, or the mean of the two middle ones for an even count
+func (rating *Rating) Median() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+
+	// positions of the middle scores, counted from 1
+	low := (rating.Count + 1) / 2
+	high := rating.Count/2 + 1
+
+	var seen uint32
+	var lowScore float64
+	for _, bucket := range rating.Histogram {
+		if seen < low && seen+bucket.Count >= low {
+			lowScore = bucket.Score
+		}
+		if seen+bucket.Count >= high {
+			return (lowScore + bucket.Score) / 2
+		}
+		seen += bucket.Count
+	}
+	return lowScore
+}
+
+// StandardDeviation is the population standard deviation of the scores
+func (rating *Rating) StandardDeviation() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+
+	mean := rating.Mean()
+	// rounding errors can make the variance slightly negative when all the scores are equal
+	variance := max(rating.SumSquares/float64(rating.Count)-mean*mean, 0)
+	return math.Sqrt(variance)
+}
+
+func (rating *Rating) clone() *Rating {
+	other := *rating
+	other.Histogram = slices.Clone(rating.Histogram)
+	return &other
 }
 
 type InMemoryRatingStore struct {
 	mutex  sync.RWMutex
 	rating map[string]*Rating
+	scores map[string]map[string]float64 // laptop ID -> username -> score
 }
 
 func NewInMemoryRatingStore() *InMemoryRatingStore {
 	return &InMemoryRatingStore{
 		rating: make(map[string]*Rating),
+		scores: make(map[string]map[string]float64),
 	}
 }
 
-func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating, error) {
+func (store *InMemoryRatingStore) Rate(laptopID string, username string, score float64) (*Rating, bool, error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
 	rating := store.rating[laptopID]
 	if rating == nil {
-		rating = &Rating{
-			Count: 1,
-			Sum:   score,
-		}
+		rating = &Rating{}
+		store.rating[laptopID] = rating
+	}
+
+	scores := store.scores[laptopID]
+	if scores == nil {
+		scores = make(map[string]float64)
+		store.scores[laptopID] = scores
+	}
+
+	previous, found := scores[username]
+	if found {
+		rating.replace(&previous, &score)
 	} else {
-		rating.Count++
-		rating.Sum += score
+		rating.replace(nil, &score)
 	}
+	scores[username] = score
 
-	store.rating[laptopID] = rating
-	return rating, nil
+	return rating.clone(), !found, nil
+}
+
+func (store *InMemoryRatingStore) Retract(laptopID string, username string) (*Rating, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, found := store.scores[laptopID][username]
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	rating := store.rating[laptopID]
+	rating.replace(&previous, nil)
+	delete(store.scores[laptopID], username)
+
+	return rating.clone(), nil
+}
+
+func (store *InMemoryRatingStore) Find(laptopID string) (*Rating, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	rating := store.rating[laptopID]
+	if rating == nil {
+		return nil, nil
+	}
+
+	return rating.clone(), nil
+}
+
+func (store *InMemoryRatingStore) List(found func(laptopID string, rating *Rating) error) error {
+	store.mutex.RLock()
+	ratings := make(map[string]*Rating, len(store.rating))
+	for laptopID, rating := range store.rating {
+		ratings[laptopID] = rating.clone()
+	}
+	store.mutex.RUnlock()
+
+	for laptopID, rating := range ratings {
+		err := found(laptopID, rating)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
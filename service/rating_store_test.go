@@ -0,0 +1,42 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatingStats(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryRatingStore()
+	for user, score := range map[string]float64{"user1": 2, "user2": 4, "user3": 4, "user4": 10} {
+		_, _, err := store.Rate("laptop1", user, score)
+		require.NoError(t, err)
+	}
+
+	rating, err := store.Find("laptop1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), rating.Count)
+	require.Equal(t, 5.0, rating.Mean())
+	require.Equal(t, 4.0, rating.Median())
+	require.InDelta(t, 3.0, rating.StandardDeviation(), 1e-9)
+	require.Equal(t, []RatingBucket{{Score: 2, Count: 1}, {Score: 4, Count: 2}, {Score: 10, Count: 1}}, rating.Histogram)
+
+	// the stats follow the replaced and retracted scores
+	_, _, err = store.Rate("laptop1", "user4", 2)
+	require.NoError(t, err)
+	rating, err = store.Retract("laptop1", "user2")
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), rating.Count)
+	require.Equal(t, 2.0, rating.Median())
+	require.InDelta(t, math.Sqrt(8.0/9), rating.StandardDeviation(), 1e-9)
+	require.Equal(t, []RatingBucket{{Score: 2, Count: 2}, {Score: 4, Count: 1}}, rating.Histogram)
+
+	// the rating handed out is a copy
+	rating.Histogram[0].Count = 10
+	rating, err = store.Find("laptop1")
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Histogram[0].Count)
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+
+	"github.com/moataz-hamed/pb/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+type ReviewStore interface {
+	// Save stores the review, replacing the one its user wrote before for the same laptop,
+	// and tells whether there was one. A review replacing a hidden one is hidden as well, its status
+	// is set to HIDDEN so posting again does not undo the moderation. It returns ErrAlreadyExists
+	// if the ID is already used
+	Save(review *pb.Review) (replaced bool, err error)
+	// Find returns the review with the given ID, or nil if there is none
+	Find(id string) (*pb.Review, error)
+	// SetStatus changes the moderation status of the review and returns it, or ErrNotFound if there is none
+	SetStatus(id string, status pb.Review_Status) (*pb.Review, error)
+	// List calls found with every review of the laptop, the newest first and then in ascending order of ID
+	List(laptopID string, found func(review *pb.Review) error) error
+}
+
+// compareReviews orders the reviews from the newest to the oldest, then by ID
+func compareReviews(a, b *pb.Review) int {
+	if result := b.GetCreatedAt().AsTime().Compare(a.GetCreatedAt().AsTime()); result != 0 {
+		return result
+	}
+	return cmp.Compare(a.GetId(), b.GetId())
+}
+
+type InMemoryReviewStore struct {
+	mutex    sync.RWMutex
+	reviews  map[string]*pb.Review
+	byLaptop map[string][]*pb.Review // laptop ID -> its reviews in the order of compareReviews
+	byUser   map[string]string       // score key of the laptop and the user -> ID of their review
+}
+
+func NewInMemoryReviewStore() *InMemoryReviewStore {
+	return &InMemoryReviewStore{
+		reviews:  make(map[string]*pb.Review),
+		byLaptop: make(map[string][]*pb.Review),
+		byUser:   make(map[string]string),
+	}
+}
+
+func (store *InMemoryReviewStore) Save(review *pb.Review) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.reviews[review.Id] != nil {
+		return false, ErrAlreadyExists
+	}
+
+	key := scoreKey(review.LaptopId, review.Username)
+	previousID, replaced := store.byUser[key]
+	if replaced {
+		previous := store.reviews[previousID]
+		if previous.Status == pb.Review_HIDDEN {
+			review.Status = pb.Review_HIDDEN
+		}
+		store.remove(previous)
+	}
+
+	other := proto.Clone(review).(*pb.Review)
+	store.reviews[other.Id] = other
+	store.byUser[key] = other.Id
+
+	reviews := store.byLaptop[other.LaptopId]
+	i, _ := slices.BinarySearchFunc(reviews, other, compareReviews)
+	store.byLaptop[other.LaptopId] = slices.Insert(reviews, i, other)
+
+	return replaced, nil
+}
+
+// remove drops the review from the indexes, the mutex must be locked by the caller
+func (store *InMemoryReviewStore) remove(review *pb.Review) {
+	delete(store.reviews, review.Id)
+
+	reviews := store.byLaptop[review.LaptopId]
+	i, found := slices.BinarySearchFunc(reviews, review, compareReviews)
+	if found {
+		store.byLaptop[review.LaptopId] = slices.Delete(reviews, i, i+1)
+	}
+}
+
+func (store *InMemoryReviewStore) Find(id string) (*pb.Review, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	review := store.reviews[id]
+	if review == nil {
+		return nil, nil
+	}
+	return proto.Clone(review).(*pb.Review), nil
+}
+
+func (store *InMemoryReviewStore) SetStatus(id string, status pb.Review_Status) (*pb.Review, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	review := store.reviews[id]
+	if review == nil {
+		return nil, ErrNotFound
+	}
+
+	review.Status = status
+	return proto.Clone(review).(*pb.Review), nil
+}
+
+func (store *InMemoryReviewStore) List(laptopID string, found func(review *pb.Review) error) error {
+	store.mutex.RLock()
+	reviews := make([]*pb.Review, len(store.byLaptop[laptopID]))
+	for i, review := range store.byLaptop[laptopID] {
+		reviews[i] = proto.Clone(review).(*pb.Review)
+	}
+	store.mutex.RUnlock()
+
+	for _, review := range reviews {
+		err := found(review)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
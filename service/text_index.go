@@ -0,0 +1,143 @@
+package service
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/moataz-hamed/pb/pb"
+)
+
+// weights of the fields a word is found in, the name and brand tell more about a laptop than its parts
+const (
+	nameWeight  = 3
+	brandWeight = 2
+	cpuWeight   = 1
+	gpuWeight   = 1
+)
+
+// a word only starting with a searched term counts less than the term itself
+const prefixFactor = 0.5
+
+// textIndex is an inverted index of the words in the brand, name, CPU and GPU names of the laptops
+type textIndex struct {
+	postings map[string]map[string]float64 // word -> laptop ID -> weight of the word in this laptop
+	words    []string                      // indexed words in ascending order, to find the ones starting with a prefix
+	docs     map[string][]string           // laptop ID -> its indexed words
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{
+		postings: make(map[string]map[string]float64),
+		docs:     make(map[string][]string),
+	}
+}
+
+// tokenize splits the text into lower case words made of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// update replaces the words of the old version of a laptop by the ones of the updated version, either of them can be nil
+func (index *textIndex) update(old *pb.Laptop, updated *pb.Laptop) {
+	if old != nil {
+		index.remove(old.GetId())
+	}
+	if updated != nil {
+		index.add(updated)
+	}
+}
+
+func (index *textIndex) add(laptop *pb.Laptop) {
+	weights := make(map[string]float64)
+	addText := func(text string, weight float64) {
+		for _, word := range tokenize(text) {
+			weights[word] += weight
+		}
+	}
+
+	addText(laptop.GetName(), nameWeight)
+	addText(laptop.GetBrand(), brandWeight)
+	addText(laptop.GetCpu().GetName(), cpuWeight)
+	for _, gpu := range laptop.GetGpu() {
+		addText(gpu.GetName(), gpuWeight)
+	}
+
+	id := laptop.GetId()
+	for word, weight := range weights {
+		posting := index.postings[word]
+		if posting == nil {
+			posting = make(map[string]float64)
+			index.postings[word] = posting
+
+			i, _ := slices.BinarySearch(index.words, word)
+			index.words = slices.Insert(index.words, i, word)
+		}
+		posting[id] = weight
+		index.docs[id] = append(index.docs[id], word)
+	}
+}
+
+func (index *textIndex) remove(id string) {
+	for _, word := range index.docs[id] {
+		posting := index.postings[word]
+		delete(posting, id)
+		if len(posting) > 0 {
+			continue
+		}
+
+		delete(index.postings, word)
+		i, found := slices.BinarySearch(index.words, word)
+		if found {
+			index.words = slices.Delete(index.words, i, i+1)
+		}
+	}
+	delete(index.docs, id)
+}
+
+// search returns the relevance score of every laptop having, for each term of the text, a word equal to it
+// or starting with it. A word scores its weight in the laptop times how rare it is among the laptops
+func (index *textIndex) search(text string) map[string]float64 {
+	var scores map[string]float64
+	total := float64(len(index.docs))
+
+	for i, term := range tokenize(text) {
+		termScores := make(map[string]float64)
+
+		start, _ := slices.BinarySearch(index.words, term)
+		for _, word := range index.words[start:] {
+			if !strings.HasPrefix(word, term) {
+				break
+			}
+
+			factor := 1.0
+			if word != term {
+				factor = prefixFactor
+			}
+
+			posting := index.postings[word]
+			idf := math.Log(1 + total/float64(len(posting)))
+			for id, weight := range posting {
+				// the best word of the laptop counts for the term, so a short prefix can't pile up scores
+				termScores[id] = max(termScores[id], weight*factor*idf)
+			}
+		}
+
+		if i == 0 {
+			scores = termScores
+			continue
+		}
+		for id := range scores {
+			score, ok := termScores[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += score
+		}
+	}
+	return scores
+}
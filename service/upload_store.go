@@ -0,0 +1,254 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrOffsetMismatch is returned when a chunk does not start where the uploaded data ends
+var ErrOffsetMismatch = errors.New("offset does not match the uploaded size")
+
+// ErrTooManyUploads is returned when an upload is started while the store holds as many sessions as it can
+var ErrTooManyUploads = errors.New("too many uploads in progress")
+
+// by default a store holds up to 1000 sessions, each keeping its file open until it is finished or expired
+const defaultMaxUploads = 1000
+
+type UploadStore interface {
+	// Start opens an upload session for an image of the laptop and returns its ID, sha256 is the
+	// expected digest of the image if it is known
+	Start(laptopID string, imageType string, sha256 string) (string, error)
+	// Write appends the data to the upload and returns the size uploaded. The offset must be the size
+	// already uploaded or ErrOffsetMismatch is returned, and ErrNotFound is returned if there is no such upload
+	Write(uploadID string, offset int64, data []byte) (int64, error)
+	// Find returns the session of the upload, or nil if there is none
+	Find(uploadID string) (*UploadSession, error)
+	// Finish calls save with the uploaded data once it is size bytes long, the session is removed if save succeeds
+	Finish(uploadID string, size int64, save func(session *UploadSession, data io.Reader) error) error
+	// RemoveExpired removes the sessions which have expired by now and returns their number
+	RemoveExpired(now time.Time) (int, error)
+}
+
+// UploadSession is an image being uploaded in several calls
+type UploadSession struct {
+	LaptopID  string
+	Type      string
+	SHA256    string    // expected hex digest of the image, empty if it is not known
+	Offset    int64     // size of the data uploaded so far
+	ExpiresAt time.Time // the session is removed if nothing is written before
+}
+
+// DiskUploadStore writes the uploaded data to a file per session, the sessions themselves are kept
+// in memory so they can't be resumed once the server restarts
+type DiskUploadStore struct {
+	folder     string
+	ttl        time.Duration
+	maxUploads int
+	mutex      sync.Mutex
+	uploads    map[string]*diskUpload
+}
+
+// DiskUploadStoreOption changes the default configuration of a DiskUploadStore
+type DiskUploadStoreOption func(store *DiskUploadStore)
+
+// WithMaxUploads sets the number of sessions the store holds at most, expired ones included until they are removed
+func WithMaxUploads(uploads int) DiskUploadStoreOption {
+	return func(store *DiskUploadStore) {
+		store.maxUploads = uploads
+	}
+}
+
+type diskUpload struct {
+	mutex   sync.Mutex // held while the data is written or saved
+	session UploadSession
+	file    *os.File
+	removed bool // set once the upload is finished or expired
+}
+
+// NewDiskUploadStore keeps the uploads in the folder, removing the files left by a previous run.
+// A session expires once no data is written to it for the TTL
+func NewDiskUploadStore(folder string, ttl time.Duration, options ...DiskUploadStoreOption) (*DiskUploadStore, error) {
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create upload folder:%w", err)
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(folder, "*.part"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list upload files:%w", err)
+	}
+	for _, path := range leftovers {
+		err := os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot remove upload file:%w", err)
+		}
+	}
+
+	store := &DiskUploadStore{
+		folder:     folder,
+		ttl:        ttl,
+		maxUploads: defaultMaxUploads,
+		uploads:    make(map[string]*diskUpload),
+	}
+	for _, option := range options {
+		option(store)
+	}
+	return store, nil
+}
+
+func (store *DiskUploadStore) Start(laptopID string, imageType string, sha256 string) (string, error) {
+	uploadID, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("cannot generate upload id:%w", err)
+	}
+
+	file, err := os.Create(filepath.Join(store.folder, uploadID.String()+".part"))
+	if err != nil {
+		return "", fmt.Errorf("cannot create upload file:%w", err)
+	}
+
+	upload := &diskUpload{
+		session: UploadSession{
+			LaptopID:  laptopID,
+			Type:      imageType,
+			SHA256:    sha256,
+			ExpiresAt: time.Now().Add(store.ttl),
+		},
+		file: file,
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if len(store.uploads) >= store.maxUploads {
+		upload.remove()
+		return "", ErrTooManyUploads
+	}
+	store.uploads[uploadID.String()] = upload
+	return uploadID.String(), nil
+}
+
+// lock returns the upload with its mutex locked, or nil if there is no such upload
+func (store *DiskUploadStore) lock(uploadID string) *diskUpload {
+	store.mutex.Lock()
+	upload := store.uploads[uploadID]
+	store.mutex.Unlock()
+
+	if upload == nil {
+		return nil
+	}
+
+	upload.mutex.Lock()
+	if upload.removed {
+		upload.mutex.Unlock()
+		return nil
+	}
+	return upload
+}
+
+func (store *DiskUploadStore) Write(uploadID string, offset int64, data []byte) (int64, error) {
+	upload := store.lock(uploadID)
+	if upload == nil {
+		return 0, ErrNotFound
+	}
+	defer upload.mutex.Unlock()
+
+	if offset != upload.session.Offset {
+		return upload.session.Offset, ErrOffsetMismatch
+	}
+
+	_, err := upload.file.WriteAt(data, offset)
+	if err != nil {
+		// a partial write is dropped so the chunk can be sent again from the same offset
+		upload.file.Truncate(offset)
+		return upload.session.Offset, fmt.Errorf("cannot write upload file:%w", err)
+	}
+
+	upload.session.Offset += int64(len(data))
+	upload.session.ExpiresAt = time.Now().Add(store.ttl)
+	return upload.session.Offset, nil
+}
+
+func (store *DiskUploadStore) Find(uploadID string) (*UploadSession, error) {
+	upload := store.lock(uploadID)
+	if upload == nil {
+		return nil, nil
+	}
+	defer upload.mutex.Unlock()
+
+	session := upload.session
+	return &session, nil
+}
+
+func (store *DiskUploadStore) Finish(uploadID string, size int64, save func(session *UploadSession, data io.Reader) error) error {
+	upload := store.lock(uploadID)
+	if upload == nil {
+		return ErrNotFound
+	}
+	defer upload.mutex.Unlock()
+
+	if size != upload.session.Offset {
+		return ErrOffsetMismatch
+	}
+
+	session := upload.session
+	err := save(&session, io.NewSectionReader(upload.file, 0, size))
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	delete(store.uploads, uploadID)
+	store.mutex.Unlock()
+	return upload.remove()
+}
+
+func (store *DiskUploadStore) RemoveExpired(now time.Time) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	removed := 0
+	for uploadID, upload := range store.uploads {
+		// an upload being written or saved is in use, so it is not expired
+		if !upload.mutex.TryLock() {
+			continue
+		}
+
+		if now.Before(upload.session.ExpiresAt) {
+			upload.mutex.Unlock()
+			continue
+		}
+
+		delete(store.uploads, uploadID)
+		err := upload.remove()
+		upload.mutex.Unlock()
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// remove closes and deletes the file of the upload, the mutex of the upload must be locked by the caller
+func (upload *diskUpload) remove() error {
+	upload.removed = true
+
+	err := upload.file.Close()
+	if err != nil {
+		return fmt.Errorf("cannot close upload file:%w", err)
+	}
+
+	err = os.Remove(upload.file.Name())
+	if err != nil {
+		return fmt.Errorf("cannot remove upload file:%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskUploadStore(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	leftover := filepath.Join(folder, "old.part")
+	require.NoError(t, os.WriteFile(leftover, []byte("old"), 0644))
+
+	store, err := NewDiskUploadStore(folder, time.Hour)
+	require.NoError(t, err)
+	require.NoFileExists(t, leftover)
+
+	uploadID, err := store.Start("laptop1", ".png", "")
+	require.NoError(t, err)
+
+	offset, err := store.Write(uploadID, 0, []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), offset)
+
+	// a chunk sent again or skipping data is refused
+	offset, err = store.Write(uploadID, 0, []byte("hello"))
+	require.ErrorIs(t, err, ErrOffsetMismatch)
+	require.Equal(t, int64(5), offset)
+	_, err = store.Write(uploadID, 6, []byte("world"))
+	require.ErrorIs(t, err, ErrOffsetMismatch)
+
+	_, err = store.Write(uploadID, 5, []byte(" world"))
+	require.NoError(t, err)
+
+	session, err := store.Find(uploadID)
+	require.NoError(t, err)
+	require.Equal(t, "laptop1", session.LaptopID)
+	require.Equal(t, ".png", session.Type)
+	require.Equal(t, int64(11), session.Offset)
+
+	save := func(session *UploadSession, data io.Reader) error {
+		content, err := io.ReadAll(data)
+		require.NoError(t, err)
+		require.Equal(t, "hello world", string(content))
+		return nil
+	}
+	require.ErrorIs(t, store.Finish(uploadID, 5, save), ErrOffsetMismatch)
+	require.NoError(t, store.Finish(uploadID, 11, save))
+
+	session, err = store.Find(uploadID)
+	require.NoError(t, err)
+	require.Nil(t, session)
+	_, err = store.Write(uploadID, 11, []byte("!"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	entries, err := os.ReadDir(folder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestDiskUploadStoreRemoveExpired(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	store, err := NewDiskUploadStore(folder, time.Hour)
+	require.NoError(t, err)
+
+	uploadID, err := store.Start("laptop1", ".png", "")
+	require.NoError(t, err)
+
+	removed, err := store.RemoveExpired(time.Now())
+	require.NoError(t, err)
+	require.Zero(t, removed)
+
+	removed, err = store.RemoveExpired(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	session, err := store.Find(uploadID)
+	require.NoError(t, err)
+	require.Nil(t, session)
+
+	entries, err := os.ReadDir(folder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestDiskUploadStoreMaxUploads(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	store, err := NewDiskUploadStore(folder, time.Hour, WithMaxUploads(2))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := store.Start("laptop1", ".png", "")
+		require.NoError(t, err)
+	}
+	_, err = store.Start("laptop1", ".png", "")
+	require.ErrorIs(t, err, ErrTooManyUploads)
+
+	entries, err := os.ReadDir(folder)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// the expired sessions make room once they are removed
+	removed, err := store.RemoveExpired(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+	_, err = store.Start("laptop1", ".png", "")
+	require.NoError(t, err)
+}
@@ -15,7 +15,7 @@ type User struct {
 func NewUser(username, password, role string) (*User, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, fmt.Errorf("can't hash the password", err)
+		return nil, fmt.Errorf("can't hash the password: %w", err)
 	}
 
 	return &User{